@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"os"
+
+	"github.com/ivanglie/iploc/pkg/iploc"
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+// snapshots prints the dataset snapshots of the data directory as JSON.
+func snapshots() error {
+	snaps, err := iploc.Snapshots(opts.DataDir)
+	if err != nil {
+		return err
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	return e.Encode(snaps)
+}
+
+// rollback activates a previous dataset snapshot of the data directory. It is loaded first to check that it is usable.
+// A running server picks it up on restart, or immediately by the rollback endpoint.
+func rollback() error {
+	info, err := iploc.Rollback(opts.Rollback.Snapshot, options()...)
+	if err != nil {
+		return fmt.Errorf("rolling back: %v", err)
+	}
+
+	log.Info(fmt.Sprintf("Snapshot %s is active", info.Dataset.Snapshot))
+	return nil
+}
+
+// admin allows requests with the admin token only. Admin endpoints are not found without the token configured.
+func admin(h nethttp.HandlerFunc) nethttp.HandlerFunc {
+	return func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if len(opts.AdminToken) == 0 {
+			nethttp.NotFound(w, r)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+opts.AdminToken)) != 1 {
+			nethttp.Error(w, "unauthorized", nethttp.StatusUnauthorized)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// adminSnapshots lists the dataset snapshots of the data directory.
+func adminSnapshots(w nethttp.ResponseWriter, r *nethttp.Request) {
+	snaps, err := db.Snapshots()
+	if err != nil {
+		nethttp.Error(w, err.Error(), nethttp.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, nethttp.StatusOK, snaps)
+}
+
+// adminRollback activates the snapshot given by the snapshot parameter, or the one before the active snapshot.
+func adminRollback(w nethttp.ResponseWriter, r *nethttp.Request) {
+	if r.Method != nethttp.MethodPost {
+		w.Header().Set("Allow", nethttp.MethodPost)
+		nethttp.Error(w, "method not allowed", nethttp.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Info("Rollback...")
+	if err := db.Rollback(r.URL.Query().Get("snapshot")); err != nil {
+		log.Error(fmt.Sprintf("rolling back: %v", err))
+		nethttp.Error(w, err.Error(), nethttp.StatusConflict)
+		return
+	}
+
+	writeJSON(w, nethttp.StatusOK, db.Info())
+}
+
+// adminStats returns the statistics of the snapshot given by the snapshot parameter, or of the loaded dataset,
+// as JSON. Snapshots other than the loaded one are parsed on the first request.
+func adminStats(w nethttp.ResponseWriter, r *nethttp.Request) {
+	id := r.URL.Query().Get("snapshot")
+	if len(id) == 0 {
+		statsHandler(w, r)
+		return
+	}
+
+	if !hasSnapshot(id) {
+		nethttp.Error(w, fmt.Sprintf("snapshot %q not found", id), nethttp.StatusNotFound)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Stats of snapshot %s...", id))
+	st, err := db.SnapshotStats(r.Context(), id)
+	if err != nil {
+		nethttp.Error(w, err.Error(), nethttp.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, nethttp.StatusOK, st)
+}
+
+// hasSnapshot reports whether the snapshot id is kept in the data directory.
+func hasSnapshot(id string) bool {
+	snaps, _ := db.Snapshots()
+	for _, s := range snaps {
+		if s.Snapshot == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeJSON writes v as JSON with status.
+func writeJSON(w nethttp.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(err.Error())
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/ivanglie/iploc/pkg/iploc"
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+// maxAddrLen is the maximum length of an address in a batch, longer lines are rejected when reading.
+const maxAddrLen = 64
+
+// errBatchTooLarge is returned by readBatch for batches of more addresses than allowed.
+var errBatchTooLarge = errors.New("too many addresses")
+
+// batchItem is the result of an address of a batch, or its error.
+type batchItem struct {
+	IP     string          `json:"ip"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// batch looks up the addresses of the request body, a JSON array or newline-delimited text, and returns their results
+// in the same order: as a JSON array, or streamed as newline-delimited JSON if requested by the Accept header.
+// The body is read completely, up to opts.BatchMax addresses, before any result is written, so that a body over
+// the limit is answered with 413 rather than cut off results; NDJSON streams the results, not the input.
+func batch(w nethttp.ResponseWriter, r *nethttp.Request) {
+	if r.Method != nethttp.MethodPost {
+		w.Header().Set("Allow", nethttp.MethodPost)
+		nethttp.Error(w, "method not allowed", nethttp.StatusMethodNotAllowed)
+		return
+	}
+
+	if !db.Info().Ready() {
+		nethttp.Error(w, iploc.ErrNotLoaded.Error(), nethttp.StatusServiceUnavailable)
+		return
+	}
+
+	body := nethttp.MaxBytesReader(w, r.Body, int64(opts.BatchMax+1)*maxAddrLen)
+	addrs, err := readBatch(body, strings.HasPrefix(r.Header.Get("Content-Type"), "application/json"), opts.BatchMax)
+	var tooLarge *nethttp.MaxBytesError
+	switch {
+	case errors.Is(err, errBatchTooLarge):
+		nethttp.Error(w, fmt.Sprintf("%v, maximum is %d", err, opts.BatchMax), nethttp.StatusRequestEntityTooLarge)
+		return
+	case errors.As(err, &tooLarge):
+		nethttp.Error(w, fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit),
+			nethttp.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		nethttp.Error(w, err.Error(), nethttp.StatusBadRequest)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Batch of %d addresses...", len(addrs)))
+
+	if !strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		// The JSON array is written once every address is looked up, so that it is never cut off.
+		items := make([]batchItem, 0, len(addrs))
+		for _, a := range addrs {
+			if r.Context().Err() != nil {
+				return
+			}
+			items = append(items, lookup(r, a))
+		}
+
+		b, err := json.Marshal(items)
+		if err != nil {
+			nethttp.Error(w, err.Error(), nethttp.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		log.Info("Batch completed")
+		return
+	}
+
+	// NDJSON is streamed line by line, a cancelled request leaves it cut off after the last complete line.
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(nethttp.Flusher)
+	for _, a := range addrs {
+		if r.Context().Err() != nil {
+			return
+		}
+
+		b, err := json.Marshal(lookup(r, a))
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	log.Info("Batch completed")
+}
+
+// lookup returns the batch item of the address a.
+func lookup(r *nethttp.Request, a string) batchItem {
+	item := batchItem{IP: a}
+
+	addr, err := netip.ParseAddr(a)
+	if err != nil {
+		item.Error = err.Error()
+		return item
+	}
+
+	res, err := db.Lookup(r.Context(), addr)
+	if err != nil {
+		item.Error = err.Error()
+		return item
+	}
+
+	item.Result = json.RawMessage(res.String())
+	return item
+}
+
+// readBatch reads at most limit addresses from a JSON array of strings (if isJSON) or from newline-delimited text.
+// Blank lines of text are skipped.
+func readBatch(r io.Reader, isJSON bool, limit int) ([]string, error) {
+	var addrs []string
+
+	add := func(a string) error {
+		if len(addrs) == limit {
+			return errBatchTooLarge
+		}
+
+		addrs = append(addrs, strings.TrimSpace(a))
+		return nil
+	}
+
+	if !isJSON {
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, maxAddrLen), maxAddrLen)
+		for s.Scan() {
+			if len(strings.TrimSpace(s.Text())) == 0 {
+				continue
+			}
+
+			if err := add(s.Text()); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := s.Err(); err != nil {
+			return nil, readError(err)
+		}
+
+		return addrs, nil
+	}
+
+	d := json.NewDecoder(r)
+	t, err := d.Token()
+	var tooLarge *nethttp.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, err
+	}
+	if err != nil || t != json.Delim('[') {
+		return nil, errors.New("reading addresses: JSON array expected")
+	}
+
+	for d.More() {
+		var a string
+		if err := d.Decode(&a); err != nil {
+			return nil, readError(err)
+		}
+
+		if err := add(a); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := d.Token(); err != nil {
+		return nil, readError(err)
+	}
+
+	return addrs, nil
+}
+
+// readError returns the error of reading addresses, or err itself if the body is larger than allowed.
+func readError(err error) error {
+	var tooLarge *nethttp.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+
+	return fmt.Errorf("reading addresses: %v", err)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ivanglie/iploc/pkg/iploc"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_batch(t *testing.T) {
+	var err error
+	db, err = iploc.Open(iploc.WithDataDir(t.TempDir()), iploc.WithLocal("../../test/data/DB.zip"))
+	assert.NoError(t, err)
+	defer db.Close()
+	opts.BatchMax = 2
+
+	post := func(ctx context.Context, body, contentType, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body)).WithContext(ctx)
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		batch(w, r)
+		return w
+	}
+
+	// JSON array
+	w := post(context.Background(), `["8.8.8.8", "x"]`, "application/json", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var items []batchItem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+	assert.Equal(t, 2, len(items))
+	assert.Contains(t, string(items[0].Result), `"Code":"US"`)
+	assert.NotEmpty(t, items[1].Error)
+
+	// NDJSON
+	w = post(context.Background(), "8.8.8.8\n9.9.9.9\n", "text/plain", "application/x-ndjson")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "\n"))
+
+	// Cancelled requests leave no partial JSON array
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = post(ctx, `["8.8.8.8"]`, "application/json", "")
+	assert.Empty(t, w.Body.String())
+
+	// Errors
+	w = post(context.Background(), `["8.8.8.8", "8.8.8.8", "8.8.8.8"]`, "application/json", "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "too many addresses, maximum is 2\n", w.Body.String())
+
+	w = post(context.Background(), `["`+strings.Repeat("1", 3*maxAddrLen)+`"]`, "application/json", "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "request body is larger than 192 bytes\n", w.Body.String())
+
+	w = post(context.Background(), strings.Repeat("\n", 4*maxAddrLen), "text/plain", "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "request body is larger than 192 bytes\n", w.Body.String())
+
+	w = post(context.Background(), `{}`, "application/json", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_readBatch(t *testing.T) {
+	// JSON array
+	addrs, err := readBatch(strings.NewReader(`["8.8.8.8", " 2001:4860:4860::8888", "x"]`), true, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8.8.8.8", "2001:4860:4860::8888", "x"}, addrs)
+
+	// Newline-delimited text
+	addrs, err = readBatch(strings.NewReader("8.8.8.8\r\n\n 2001:4860:4860::8888 \n"), false, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8.8.8.8", "2001:4860:4860::8888"}, addrs)
+
+	// Errors
+	_, err = readBatch(strings.NewReader(`["8.8.8.8", "9.9.9.9"]`), true, 1)
+	assert.Equal(t, errBatchTooLarge, err)
+
+	_, err = readBatch(strings.NewReader("8.8.8.8\n9.9.9.9\n"), false, 1)
+	assert.Equal(t, errBatchTooLarge, err)
+
+	_, err = readBatch(strings.NewReader(`{"ip": "8.8.8.8"}`), true, 1)
+	assert.Equal(t, "reading addresses: JSON array expected", err.Error())
+
+	_, err = readBatch(strings.NewReader(`["8.8.8.8", 1]`), true, 2)
+	assert.Equal(t, "reading addresses: json: cannot unmarshal number into Go value of type string", err.Error())
+
+	_, err = readBatch(strings.NewReader(strings.Repeat("1", maxAddrLen+1)), false, 1)
+	assert.Equal(t, "reading addresses: bufio.Scanner: token too long", err.Error())
+}
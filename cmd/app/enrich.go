@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ivanglie/iploc/pkg/iploc"
+	"github.com/ivanglie/iploc/pkg/log"
+	"github.com/rs/zerolog"
+)
+
+// enrichProperties are the properties appended by enrich by default.
+var enrichProperties = []string{"Code", "Country", "Region", "City", "Latitude", "Longitude"}
+
+// enrichStats are the counts of rows of an enrichment.
+type enrichStats struct {
+	rows     int
+	located  int
+	notFound int
+	special  int // Rows with a special-purpose address not located by the internal networks.
+	invalid  int // Rows with an unparseable or missing address.
+}
+
+// enrich appends the properties of the addresses of the IP column to the rows of the input CSV file or stdin.
+// The output is written to stdout unless an output file is set, logs are written to stderr.
+func enrich() (err error) {
+	level := zerolog.InfoLevel
+	if opts.Dbg {
+		level = zerolog.DebugLevel
+	}
+	log.SetLogConfig(level, os.Stderr)
+
+	e := opts.Enrich
+	props := e.Properties
+	if len(props) == 0 {
+		props = enrichProperties
+	}
+
+	for _, p := range props {
+		if !iploc.IsProperty(p) {
+			return fmt.Errorf("unknown property %q", p)
+		}
+	}
+
+	in := io.Reader(os.Stdin)
+	if name := e.Args.Input; len(name) != 0 && name != "-" {
+		var f *os.File
+		if f, err = os.Open(name); err != nil {
+			return
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if db, err = iploc.Open(options()...); err != nil {
+		return
+	}
+	defer db.Close()
+
+	out := io.Writer(os.Stdout)
+	if len(e.Output) != 0 {
+		var f *os.File
+		if f, err = os.Create(e.Output); err != nil {
+			return
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		out = f
+	}
+
+	log.Info("Enrich...")
+	s, err := enrichCSV(context.Background(), db, in, out, e.Column, !e.NoHeader, props)
+	if err != nil {
+		return fmt.Errorf("enriching: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d rows: %d located, %d not found, %d special-purpose, %d invalid addresses\n", s.rows,
+		s.located, s.notFound, s.special, s.invalid)
+	return nil
+}
+
+// enrichCSV copies the CSV of in to out, appending the properties props of the address in column to every row.
+// The column is a name of the header or a 1-based index. Cells of addresses that are unparseable, missing
+// or not found are left blank. Rows shorter than the header, or than the column without a header, are padded
+// with blank cells, so that the properties are always in the same columns.
+func enrichCSV(ctx context.Context, db *iploc.DB, in io.Reader, out io.Writer, column string, header bool,
+	props []string) (s enrichStats, err error) {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	w := csv.NewWriter(out)
+
+	col, width := -1, 0
+	if n, err := strconv.Atoi(column); err == nil {
+		if n < 1 {
+			return s, fmt.Errorf("column index %d is out of range", n)
+		}
+		col = n - 1
+	}
+
+	if header {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return s, errors.New("empty input")
+		}
+		if err != nil {
+			return s, err
+		}
+
+		for i, h := range rec {
+			if col < 0 && strings.TrimSpace(h) == column {
+				col = i
+			}
+		}
+
+		if col < 0 {
+			return s, fmt.Errorf("column %q not found", column)
+		}
+		width = len(rec)
+
+		if err := w.Write(append(rec, props...)); err != nil {
+			return s, err
+		}
+	} else if col < 0 {
+		return s, fmt.Errorf("column %q is not an index, a header is required to find it by name", column)
+	}
+
+	if width < col+1 {
+		width = col + 1
+	}
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return s, err
+		}
+
+		s.rows++
+		for len(rec) < width {
+			rec = append(rec, "")
+		}
+		values := make([]string, len(props))
+
+		var addr netip.Addr
+		if col < len(rec) {
+			addr, _ = netip.ParseAddr(strings.TrimSpace(rec[col]))
+		}
+
+		if !addr.IsValid() {
+			s.invalid++
+		} else if res, err := db.Lookup(ctx, addr); err != nil {
+			if ctx.Err() != nil {
+				return s, ctx.Err()
+			}
+			s.notFound++
+		} else if len(res.Class) != 0 && len(res.Code) == 0 {
+			s.special++
+		} else if len(res.Code) == 0 {
+			// Ranges of the database without a country, "-" in IP2Location, locate nothing.
+			s.notFound++
+		} else {
+			s.located++
+			for i, p := range props {
+				values[i] = res.Property(p)
+			}
+		}
+
+		if err := w.Write(append(rec, values...)); err != nil {
+			return s, err
+		}
+	}
+
+	w.Flush()
+	return s, w.Error()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ivanglie/iploc/pkg/iploc"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_enrichCSV(t *testing.T) {
+	db, err := iploc.Open(iploc.WithDataDir(t.TempDir()), iploc.WithLocal("../../test/data/DB.zip"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	in := "user,ip\n" +
+		"a,8.8.8.8\n" +
+		"b, 2001:4860:4860::8888\n" +
+		"c,9.9.9.9\n" +
+		"d,bad\n" +
+		"e\n" +
+		"f,10.0.0.1\n"
+
+	var out bytes.Buffer
+	s, err := enrichCSV(context.Background(), db, strings.NewReader(in), &out, "ip", true, []string{"Code", "City", "ISP"})
+	assert.NoError(t, err)
+	assert.Equal(t, enrichStats{rows: 6, located: 2, notFound: 1, special: 1, invalid: 2}, s)
+	assert.Equal(t, "user,ip,Code,City,ISP\n"+
+		"a,8.8.8.8,US,Mountain View,\n"+
+		"b,\" 2001:4860:4860::8888\",GB,Upper Clapton,\n"+
+		"c,9.9.9.9,,,\n"+
+		"d,bad,,,\n"+
+		"e,,,,\n"+
+		"f,10.0.0.1,,,\n", out.String())
+
+	// Ranges without a country are not found
+	out.Reset()
+	s, err = enrichCSV(context.Background(), db, strings.NewReader("ip\n2001:4861::5\n"), &out, "ip", true,
+		[]string{"Code", "City"})
+	assert.NoError(t, err)
+	assert.Equal(t, enrichStats{rows: 1, notFound: 1}, s)
+	assert.Equal(t, "ip,Code,City\n2001:4861::5,,\n", out.String())
+
+	// Rows shorter than the header are padded
+	out.Reset()
+	s, err = enrichCSV(context.Background(), db, strings.NewReader("ip,user,note\n8.8.8.8,a\n"), &out, "ip", true,
+		[]string{"Code"})
+	assert.NoError(t, err)
+	assert.Equal(t, enrichStats{rows: 1, located: 1}, s)
+	assert.Equal(t, "ip,user,note,Code\n8.8.8.8,a,,US\n", out.String())
+
+	// Column by index without a header
+	out.Reset()
+	s, err = enrichCSV(context.Background(), db, strings.NewReader("8.8.8.8,a\n"), &out, "1", false, []string{"Country"})
+	assert.NoError(t, err)
+	assert.Equal(t, enrichStats{rows: 1, located: 1}, s)
+	assert.Equal(t, "8.8.8.8,a,United States of America\n", out.String())
+
+	// Rows shorter than the column are padded to it
+	out.Reset()
+	s, err = enrichCSV(context.Background(), db, strings.NewReader("a,b,8.8.8.8\nc\n"), &out, "3", false,
+		[]string{"Code"})
+	assert.NoError(t, err)
+	assert.Equal(t, enrichStats{rows: 2, located: 1, invalid: 1}, s)
+	assert.Equal(t, "a,b,8.8.8.8,US\nc,,,\n", out.String())
+
+	// Errors
+	_, err = enrichCSV(context.Background(), db, strings.NewReader(in), &out, "addr", true, nil)
+	assert.Equal(t, `column "addr" not found`, err.Error())
+
+	_, err = enrichCSV(context.Background(), db, strings.NewReader(in), &out, "ip", false, nil)
+	assert.Equal(t, `column "ip" is not an index, a header is required to find it by name`, err.Error())
+
+	_, err = enrichCSV(context.Background(), db, strings.NewReader(in), &out, "0", true, nil)
+	assert.Equal(t, "column index 0 is out of range", err.Error())
+
+	_, err = enrichCSV(context.Background(), db, strings.NewReader(""), &out, "ip", true, nil)
+	assert.Equal(t, "empty input", err.Error())
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ivanglie/iploc/pkg/iploc"
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+// export loads the database and writes it to the output file in the export format.
+func export() (err error) {
+	if db, err = iploc.Open(options()...); err != nil {
+		return
+	}
+	defer db.Close()
+
+	log.Info(fmt.Sprintf("Export to %s...", opts.Export.Output))
+
+	var f *os.File
+	if f, err = os.Create(opts.Export.Output); err != nil {
+		return
+	}
+
+	if err = db.ExportMMDB(f); err != nil {
+		f.Close()
+		os.Remove(opts.Export.Output)
+		return fmt.Errorf("exporting: %v", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return
+	}
+
+	log.Info("Export completed")
+	return
+}
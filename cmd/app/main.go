@@ -3,35 +3,93 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	nethttp "net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/ivanglie/iploc/pkg/log"
 	"github.com/rs/zerolog"
 
 	"github.com/ivanglie/iploc/internal/database"
 	"github.com/ivanglie/iploc/internal/http"
+	"github.com/ivanglie/iploc/pkg/iploc"
 	"github.com/jessevdk/go-flags"
 )
 
 var (
 	opts struct {
-		Token string `long:"token" env:"TOKEN" description:"IP2Location token"`
-		Dbg   bool   `long:"dbg" env:"DEBUG" description:"Use debug"`
-		Local bool   `long:"local" env:"LOCAL" description:"For local development"`
+		Vendor string `long:"vendor" env:"VENDOR" default:"ip2location" choice:"ip2location" choice:"maxmind" description:"Database vendor"`
+		Token  string `long:"token" env:"TOKEN" description:"IP2Location token or MaxMind license key"`
+		DBCode string `long:"db-code" env:"DB_CODE" description:"IP2Location database code of DB1 to DB26 (DB11LITEIPV6 by default, BIN codes like DB11LITEBINIPV6 are read in BIN format) or MaxMind edition ID (GeoLite2-City by default)"`
+		Dbg    bool   `long:"dbg" env:"DEBUG" description:"Use debug"`
+		Local  bool   `long:"local" env:"LOCAL" description:"For local development"`
+		SHA256 string `long:"sha256" env:"SHA256" description:"Expected SHA-256 of the downloaded archive in hex (optional)"`
+		Size   int64  `long:"size" env:"SIZE" description:"Expected size of the downloaded archive in bytes (optional)"`
+
+		Update       string        `long:"update" env:"UPDATE" description:"Schedule of database updates: interval like 720h or cron expression like \"0 3 * * 3\" (disabled by default)"`
+		UpdateJitter time.Duration `long:"update-jitter" env:"UPDATE_JITTER" default:"30m" description:"Maximum random delay of scheduled updates"`
+		State        string        `long:"state" env:"STATE" description:"File of the time of the last successful update (state.json in the data directory by default)"`
+
+		DataDir string        `long:"data-dir" env:"DATA_DIR" default:"data" description:"Directory of the prepared data, reused across restarts"`
+		MaxAge  time.Duration `long:"max-age" env:"MAX_AGE" default:"720h" description:"Age of the prepared data after which it is downloaded again on startup, unless updates are scheduled"`
+
+		Export struct {
+			Format string `long:"format" default:"mmdb" choice:"mmdb" description:"Export format"`
+			Output string `long:"output" short:"o" default:"iploc.mmdb" description:"Output file"`
+		} `command:"export" description:"Export the database to a file and exit"`
+
+		CacheEntries int   `long:"cache-entries" env:"CACHE_ENTRIES" description:"Maximum number of cached lookups (the cache is disabled unless this or --cache-memory is set)"`
+		CacheMemory  int64 `long:"cache-memory" env:"CACHE_MEMORY" description:"Maximum estimated size of cached lookups in bytes"`
+
+		InternalNetworks string `long:"internal-networks" env:"INTERNAL_NETWORKS" description:"JSON file of private networks to their properties, like {\"10.1.0.0/16\": {\"Code\": \"DE\", \"City\": \"Berlin\"}}, locating private addresses"`
+
+		Unwrap bool `long:"unwrap" env:"UNWRAP" description:"Locate the IPv4 address embedded in 6to4, Teredo, NAT64 and IPv4-compatible IPv6 addresses"`
+
+		BatchMax int `long:"batch-max" env:"BATCH_MAX" default:"1000" description:"Maximum number of addresses of a batch request (at least 1)"`
+
+		AdminToken string `long:"admin-token" env:"ADMIN_TOKEN" description:"Bearer token of the admin endpoints, which are disabled without it"`
+		Keep       int    `long:"keep" env:"KEEP" default:"3" description:"Number of dataset snapshots kept in the data directory"`
+
+		Enrich struct {
+			Column     string   `long:"column" short:"c" default:"ip" description:"IP column by its name in the header or by its 1-based index"`
+			Properties []string `long:"property" short:"p" description:"Property to append, like City or ISP, repeatable (Code, Country, Region, City, Latitude and Longitude by default)"`
+			Output     string   `long:"output" short:"o" description:"Output file (stdout by default)"`
+			NoHeader   bool     `long:"no-header" description:"The input has no header row"`
+			Args       struct {
+				Input string `positional-arg-name:"input" description:"CSV file (stdin if omitted or -)"`
+			} `positional-args:"yes"`
+		} `command:"enrich" description:"Append location columns to the IP column of a CSV file and exit"`
+
+		Stats struct {
+			Format   string `long:"format" default:"json" choice:"json" choice:"text" description:"Output format"`
+			Snapshot string `long:"snapshot" description:"ID of the snapshot of the data directory, the active dataset by default"`
+		} `command:"stats" description:"Print the counts of ranges and addresses by country and region, the coverage and the gaps of the database and exit"`
+
+		Snapshots struct{} `command:"snapshots" description:"List the dataset snapshots of the data directory and exit"`
+
+		Rollback struct {
+			Snapshot string `long:"snapshot" description:"ID of the snapshot to activate, the one before the active snapshot by default"`
+		} `command:"rollback" description:"Activate a previous dataset snapshot of the data directory and exit"`
 	}
 
-	db      *database.DB
-	version = "unknown"
+	db       *iploc.DB
+	internal map[netip.Prefix]map[string]string // Private networks of --internal-networks to their properties.
+	version  = "unknown"
 )
 
 func main() {
-	fmt.Printf("iploc %s\n", version)
+	fmt.Fprintf(os.Stderr, "iploc %s\n", version)
 
 	p := flags.NewParser(&opts, flags.PrintErrors|flags.PassDoubleDash|flags.HelpFlag)
+	p.SubcommandsOptional = true
 	if _, err := p.Parse(); err != nil {
 		if err.(*flags.Error).Type != flags.ErrHelp {
 			fmt.Printf("[ERROR] iploc error: %v", err)
@@ -43,16 +101,68 @@ func main() {
 		log.SetLogConfig(zerolog.DebugLevel, os.Stdout)
 	}
 
-	db = database.NewDB()
-	go func() {
-		if err := db.Init(opts.Local, opts.Token, "."); err != nil {
+	if len(opts.InternalNetworks) != 0 {
+		var err error
+		if internal, err = readInternalNetworks(opts.InternalNetworks); err != nil {
+			log.Error(err.Error())
+			os.Exit(2)
+		}
+	}
+
+	if p.Active != nil {
+		var err error
+		switch p.Active.Name {
+		case "export":
+			err = export()
+		case "enrich":
+			err = enrich()
+		case "stats":
+			err = stats()
+		case "snapshots":
+			err = snapshots()
+		case "rollback":
+			err = rollback()
+		}
+
+		if err != nil {
 			log.Error(err.Error())
+			os.Exit(1)
 		}
-	}()
+		return
+	}
+
+	if opts.BatchMax <= 0 {
+		log.Error(fmt.Sprintf("invalid --batch-max %d, must be positive", opts.BatchMax))
+		os.Exit(2)
+	}
+
+	var err error
+	db, err = iploc.Open(append(options(), iploc.WithBackgroundLoading(), iploc.WithReloadPolicy(iploc.ReloadPolicy{
+		Schedule:  opts.Update,
+		Jitter:    opts.UpdateJitter,
+		MaxAge:    opts.MaxAge,
+		StatePath: opts.State,
+	}))...)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(2)
+	}
+	defer db.Close()
+
+	go reloadOnSignal()
 
 	h := nethttp.NewServeMux()
 	h.HandleFunc("/", index)
 	h.HandleFunc("/search", search)
+	h.HandleFunc("/batch", batch)
+	h.HandleFunc("/ranges", ranges)
+	h.HandleFunc("/networks", networks)
+	h.HandleFunc("/stats", statsHandler)
+	h.HandleFunc("/healthz", healthz)
+	h.HandleFunc("/readyz", readyz)
+	h.HandleFunc("/admin/snapshots", admin(adminSnapshots))
+	h.HandleFunc("/admin/rollback", admin(adminRollback))
+	h.HandleFunc("/admin/stats", admin(adminStats))
 
 	s := http.NewServer(":8080", h)
 
@@ -62,6 +172,56 @@ func main() {
 	}
 }
 
+// options returns the options of the database by the flags, without scheduled updates.
+func options() []iploc.Option {
+	o := []iploc.Option{
+		iploc.WithDataDir(opts.DataDir),
+		iploc.WithProvider(opts.Vendor, opts.DBCode),
+		iploc.WithToken(opts.Token),
+		iploc.WithVerification(opts.SHA256, opts.Size),
+		iploc.WithSnapshots(opts.Keep),
+		iploc.WithCache(opts.CacheEntries, opts.CacheMemory),
+		iploc.WithInternalNetworks(internal),
+		iploc.WithReloadPolicy(iploc.ReloadPolicy{MaxAge: opts.MaxAge}),
+	}
+
+	if opts.Local {
+		o = append(o, iploc.WithLocal(sample()))
+	}
+
+	if opts.Unwrap {
+		o = append(o, iploc.WithUnwrap())
+	}
+
+	return o
+}
+
+// sample returns the sample archive in test/data of the provider of the flags, used by --local.
+// An unknown provider has none, and Open reports it.
+func sample() string {
+	p, err := database.NewProvider(opts.Vendor, opts.DBCode)
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join("test", "data", p.Sample())
+}
+
+// reloadOnSignal reloads the database on SIGHUP, the current one keeps serving meanwhile.
+func reloadOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	for range c {
+		log.Info("Reload...")
+		if err := db.Reload(); err != nil {
+			log.Error(fmt.Sprintf("reloading: %v", err))
+			continue
+		}
+		log.Info("Reload completed")
+	}
+}
+
 func index(w nethttp.ResponseWriter, r *nethttp.Request) {
 	log.Info("Index")
 
@@ -92,24 +252,35 @@ func search(w nethttp.ResponseWriter, r *nethttp.Request) {
 	a := r.URL.Query().Get("ip")
 	log.Info(fmt.Sprintf("user ip: %s", a))
 
-	loc, err := db.Search(a)
+	var res iploc.Result
+	addr, err := netip.ParseAddr(a)
+	if err == nil {
+		res, err = db.Lookup(r.Context(), addr)
+	}
+
+	if errors.Is(err, iploc.ErrNotLoaded) {
+		log.Error(err.Error())
+		nethttp.Error(w, err.Error(), nethttp.StatusServiceUnavailable)
+		return
+	}
+
 	if err != nil {
 		log.Error(err.Error())
 		fmt.Fprintln(w, err)
 	}
 
-	log.Debug(fmt.Sprintf("loc: %v", loc))
+	log.Debug(fmt.Sprintf("loc: %v", res))
 	log.Info("Search completed")
 
 	if acceptHeader := r.Header.Get("Accept"); strings.Contains(acceptHeader, "application/json") {
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintln(w, loc)
+		fmt.Fprintln(w, res)
 
 		return
 	}
 
 	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, []byte(loc.String()), "", "\t"); err != nil {
+	if err := json.Indent(&prettyJSON, []byte(res.String()), "", "\t"); err != nil {
 		nethttp.Error(w, err.Error(), nethttp.StatusInternalServerError)
 		return
 	}
@@ -125,3 +296,20 @@ func search(w nethttp.ResponseWriter, r *nethttp.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	t.Execute(w, prettyJSON.String())
 }
+
+// healthz reports that the process is alive.
+func healthz(w nethttp.ResponseWriter, r *nethttp.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports the state of the database, with status 503 until a dataset is loaded.
+func readyz(w nethttp.ResponseWriter, r *nethttp.Request) {
+	info := db.Info()
+
+	status := nethttp.StatusOK
+	if !info.Ready() {
+		status = nethttp.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, info)
+}
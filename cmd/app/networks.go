@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"strings"
+
+	"github.com/ivanglie/iploc/pkg/iploc"
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+// networks returns the ranges and the networks of the location given by the code, region or city parameter,
+// a region or city in the country of the code parameter if both are given, as JSON or, if requested by the Accept header, as plain text with a network per line, IPv4 ones first.
+func networks(w nethttp.ResponseWriter, r *nethttp.Request) {
+	q := r.URL.Query()
+	property, value, code := "Code", q.Get("code"), ""
+	for _, p := range []string{"Region", "City"} {
+		if v := q.Get(strings.ToLower(p)); len(v) != 0 {
+			if property != "Code" {
+				nethttp.Error(w, "only one of region and city is allowed", nethttp.StatusBadRequest)
+				return
+			}
+			property, value, code = p, v, value
+		}
+	}
+
+	if len(value) == 0 {
+		nethttp.Error(w, "code, region or city is required", nethttp.StatusBadRequest)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Networks of %s %q...", property, value))
+	n, err := db.Networks(r.Context(), property, value, code)
+	switch {
+	case errors.Is(err, iploc.ErrNotLoaded):
+		nethttp.Error(w, err.Error(), nethttp.StatusServiceUnavailable)
+		return
+	case err != nil:
+		nethttp.Error(w, err.Error(), nethttp.StatusBadRequest)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Networks completed: %d IPv4 and %d IPv6 networks", len(n.IPv4.CIDRs), len(n.IPv6.CIDRs)))
+
+	if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+		w.Header().Set("Content-Type", "text/plain")
+		for _, l := range []iploc.NetworkList{n.IPv4, n.IPv6} {
+			for _, p := range l.CIDRs {
+				fmt.Fprintln(w, p)
+			}
+		}
+		return
+	}
+
+	writeJSON(w, nethttp.StatusOK, n)
+}
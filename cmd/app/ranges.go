@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"net/netip"
+	"strconv"
+
+	"github.com/ivanglie/iploc/pkg/iploc"
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+const (
+	rangesLimit    = 100  // Records of a page of ranges by default.
+	maxRangesLimit = 1000 // Maximum records of a page of ranges.
+)
+
+// rangesPage is a page of the records overlapping a queried range.
+type rangesPage struct {
+	First   string         `json:"first"`
+	Last    string         `json:"last"`
+	Offset  int            `json:"offset"`
+	Limit   int            `json:"limit"`
+	Records []iploc.Record `json:"records"`
+	Next    *int           `json:"next,omitempty"` // Offset of the next page, if any.
+}
+
+// ranges returns the records overlapping the network of the cidr parameter or the range from start to end,
+// paginated by the offset and limit parameters.
+func ranges(w nethttp.ResponseWriter, r *nethttp.Request) {
+	log.Info("Ranges...")
+
+	q := r.URL.Query()
+	first, last, err := queryRange(q.Get("cidr"), q.Get("start"), q.Get("end"))
+	if err != nil {
+		nethttp.Error(w, err.Error(), nethttp.StatusBadRequest)
+		return
+	}
+
+	offset, limit := 0, rangesLimit
+	if s := q.Get("offset"); len(s) != 0 {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			nethttp.Error(w, fmt.Sprintf("invalid offset %q", s), nethttp.StatusBadRequest)
+			return
+		}
+	}
+
+	if s := q.Get("limit"); len(s) != 0 {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxRangesLimit {
+			nethttp.Error(w, fmt.Sprintf("invalid limit %q, from 1 to %d", s, maxRangesLimit), nethttp.StatusBadRequest)
+			return
+		}
+	}
+
+	records, more, err := db.Overlaps(r.Context(), first, last, offset, limit)
+	switch {
+	case errors.Is(err, iploc.ErrNotLoaded):
+		nethttp.Error(w, err.Error(), nethttp.StatusServiceUnavailable)
+		return
+	case err != nil:
+		nethttp.Error(w, err.Error(), nethttp.StatusBadRequest)
+		return
+	}
+
+	if records == nil {
+		records = []iploc.Record{}
+	}
+
+	page := rangesPage{First: first.String(), Last: last.String(), Offset: offset, Limit: limit, Records: records}
+
+	if more {
+		next := offset + limit
+		page.Next = &next
+	}
+
+	log.Info(fmt.Sprintf("Ranges completed: %d records from %s to %s", len(records), first, last))
+	writeJSON(w, nethttp.StatusOK, page)
+}
+
+// queryRange returns the range of the network cidr, or the range from start to end if cidr is empty.
+func queryRange(cidr, start, end string) (first, last netip.Addr, err error) {
+	if len(cidr) != 0 {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return first, last, err
+		}
+
+		first, last = iploc.PrefixRange(p)
+		return first, last, nil
+	}
+
+	if len(start) == 0 || len(end) == 0 {
+		return first, last, errors.New("cidr or start and end are required")
+	}
+
+	if first, err = netip.ParseAddr(start); err != nil {
+		return
+	}
+
+	last, err = netip.ParseAddr(end)
+	return
+}
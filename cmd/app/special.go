@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+)
+
+// readInternalNetworks reads the JSON object of private networks to their properties, like
+// {"10.1.0.0/16": {"Code": "DE", "City": "Berlin"}}, from the file name.
+func readInternalNetworks(name string) (map[netip.Prefix]map[string]string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]map[string]string
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("reading internal networks: %v", err)
+	}
+
+	networks := make(map[netip.Prefix]map[string]string, len(m))
+	for s, props := range m {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("reading internal networks: %v", err)
+		}
+		networks[p] = props
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_readInternalNetworks(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "internal.json")
+	assert.NoError(t, os.WriteFile(name, []byte(`{"10.1.0.0/16": {"Code": "DE", "City": "Berlin"}}`), 0644))
+
+	m, err := readInternalNetworks(name)
+	assert.NoError(t, err)
+	assert.Equal(t, map[netip.Prefix]map[string]string{
+		netip.MustParsePrefix("10.1.0.0/16"): {"Code": "DE", "City": "Berlin"},
+	}, m)
+
+	// Errors
+	assert.NoError(t, os.WriteFile(name, []byte(`{"10.1.0.0": {}}`), 0644))
+	_, err = readInternalNetworks(name)
+	assert.Equal(t, `reading internal networks: netip.ParsePrefix("10.1.0.0"): no '/'`, err.Error())
+
+	assert.NoError(t, os.WriteFile(name, []byte(`[]`), 0644))
+	_, err = readInternalNetworks(name)
+	assert.Error(t, err)
+}
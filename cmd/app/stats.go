@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ivanglie/iploc/pkg/iploc"
+	"github.com/ivanglie/iploc/pkg/log"
+	"github.com/rs/zerolog"
+)
+
+// stats loads the database, or only the snapshot of --snapshot, and prints its statistics to stdout.
+// Logs are written to stderr.
+func stats() (err error) {
+	level := zerolog.InfoLevel
+	if opts.Dbg {
+		level = zerolog.DebugLevel
+	}
+	log.SetLogConfig(level, os.Stderr)
+
+	if id := opts.Stats.Snapshot; len(id) != 0 {
+		st, err := iploc.SnapshotStats(id, options()...)
+		if err != nil {
+			return err
+		}
+
+		return writeStats(os.Stdout, st, opts.Stats.Format)
+	}
+
+	if db, err = iploc.Open(options()...); err != nil {
+		return
+	}
+	defer db.Close()
+
+	st, err := db.Stats(context.Background())
+	if err != nil {
+		return
+	}
+
+	return writeStats(os.Stdout, st, opts.Stats.Format)
+}
+
+// writeStats writes st as indented JSON or, in the text format, as a summary and a table of countries
+// by code with their regions indented.
+func writeStats(w io.Writer, st *iploc.Stats, format string) error {
+	if format != "text" {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(st)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Version\tRanges\tAddresses\tCoverage\tGaps\tGap addresses")
+	for _, c := range []struct {
+		name string
+		iploc.Coverage
+	}{{"IPv4", st.IPv4}, {"IPv6", st.IPv6}} {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%.6g%%\t%d\t%s\n", c.name, c.Ranges, c.Addresses, c.Ratio*100, c.Gaps, c.GapAddresses)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Country\tRanges\tIPv4\tIPv6")
+	for _, code := range sortedKeys(st.Countries) {
+		c := st.Countries[code]
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", code, c.Ranges, c.IPv4, c.IPv6)
+		for _, name := range sortedKeys(c.Regions) {
+			r := c.Regions[name]
+			fmt.Fprintf(tw, "  %s\t%d\t%s\t%s\n", name, r.Ranges, r.IPv4, r.IPv6)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// statsHandler returns the statistics of the loaded dataset as JSON.
+func statsHandler(w nethttp.ResponseWriter, r *nethttp.Request) {
+	log.Info("Stats")
+
+	st, err := db.Stats(r.Context())
+	switch {
+	case errors.Is(err, iploc.ErrNotLoaded):
+		nethttp.Error(w, err.Error(), nethttp.StatusServiceUnavailable)
+		return
+	case err != nil:
+		nethttp.Error(w, err.Error(), nethttp.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, nethttp.StatusOK, st)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ivanglie/iploc/pkg/iploc"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_writeStats(t *testing.T) {
+	db, err := iploc.Open(iploc.WithDataDir(t.TempDir()), iploc.WithLocal("../../test/data/DB.zip"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	st, err := db.Stats(context.Background())
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeStats(&buf, st, "text"))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "Version  Ranges  Addresses                       Coverage      Gaps  Gap addresses", lines[0])
+	assert.Equal(t, "IPv4     10      5632                            0.00013113%   2     4294961664", lines[1])
+	assert.Equal(t, "Country             Ranges  IPv4  IPv6", lines[4])
+	assert.Equal(t, "CA                  1       256   0", lines[5])
+	assert.Equal(t, "  Quebec            1       256   0", lines[6])
+
+	buf.Reset()
+	assert.NoError(t, writeStats(&buf, st, "json"))
+	assert.Contains(t, buf.String(), `"addresses": "5632"`)
+}
+
+// sampleVersion writes a copy of the sample archive with the comment to path, a new version of the same data.
+func sampleVersion(t *testing.T, path, comment string) {
+	r, err := zip.OpenReader("../../test/data/DB.zip")
+	assert.NoError(t, err)
+	defer r.Close()
+
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for _, file := range r.File {
+		assert.NoError(t, w.Copy(file))
+	}
+	assert.NoError(t, w.SetComment(comment))
+	assert.NoError(t, w.Close())
+}
+
+func Test_statsHandler(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "DB.zip")
+	sampleVersion(t, local, "1")
+
+	var err error
+	db, err = iploc.Open(iploc.WithDataDir(t.TempDir()), iploc.WithLocal(local))
+	assert.NoError(t, err)
+	defer db.Close()
+	sampleVersion(t, local, "2")
+	assert.NoError(t, db.Reload())
+
+	get := func(h http.HandlerFunc, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set("Authorization", "Bearer token")
+		h(w, r)
+		return w
+	}
+
+	// Snapshots are ignored by the public endpoint
+	w := get(statsHandler, "/stats?snapshot=1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"snapshot":"2"`)
+
+	// A previous snapshot, to compare with, by the admin endpoint
+	opts.AdminToken = "token"
+	defer func() { opts.AdminToken = "" }()
+
+	w = get(admin(adminStats), "/admin/stats?snapshot=1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"snapshot":"1"`)
+
+	w = get(admin(adminStats), "/admin/stats")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"snapshot":"2"`)
+
+	w = get(admin(adminStats), "/admin/stats?snapshot=5")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "snapshot \"5\" not found\n", w.Body.String())
+
+	opts.AdminToken = "other"
+	w = get(admin(adminStats), "/admin/stats?snapshot=1")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,158 @@
+// Package cache is a bounded LRU cache with coalescing of concurrent loads of the same key.
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// LRU is a cache evicting its least recently used entries beyond a number of entries or bytes,
+// as measured by its size function. A zero bound is no bound. It is safe for concurrent use.
+type LRU[K comparable, V any] struct {
+	maxEntries int
+	maxBytes   int64
+
+	size func(K, V) int64
+
+	mu    sync.Mutex
+	ll    *list.List // Entries, the most recently used first.
+	items map[K]*list.Element
+	bytes int64
+
+	calls map[K]*call[V] // Loads in flight.
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+}
+
+// errPanicked is returned to the callers waiting for a load that panicked.
+var errPanicked = errors.New("load panicked")
+
+// call is a load in flight, done when wg is.
+type call[V any] struct {
+	wg    sync.WaitGroup
+	value V
+	err   error
+}
+
+// New returns a cache bounded by maxEntries entries and maxBytes bytes, measuring entries by size.
+func New[K comparable, V any](maxEntries int, maxBytes int64, size func(K, V) int64) *LRU[K, V] {
+	return &LRU[K, V]{maxEntries: maxEntries, maxBytes: maxBytes, size: size, ll: list.New(),
+		items: map[K]*list.Element{}, calls: map[K]*call[V]{}}
+}
+
+// Get returns the value of key, if cached, and marks it as recently used.
+func (c *LRU[K, V]) Get(key K) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(key)
+}
+
+func (c *LRU[K, V]) get(key K) (value V, ok bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return
+	}
+
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry[K, V]).value, true
+}
+
+// Add caches value of key and evicts the least recently used entries beyond the bounds.
+// A value larger than the bound of bytes is not cached.
+func (c *LRU[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(key, value)
+}
+
+func (c *LRU[K, V]) add(key K, value V) {
+	var size int64
+	if c.size != nil {
+		size = c.size(key, value)
+	}
+
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		c.bytes += size - e.size
+		e.value, e.size = value, size
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, size: size})
+		c.bytes += size
+	}
+
+	for c.ll.Len() > 0 && (c.maxEntries > 0 && c.ll.Len() > c.maxEntries || c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *LRU[K, V]) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*entry[K, V])
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
+
+// GetOrLoad returns the value of key, loading and caching it on a miss. Load returns the value and the key
+// it is cached by, which may differ from key, like a key covering several ones. Concurrent loads of the same key
+// are coalesced: one caller loads it and the others wait for its value. Errors are returned but not cached.
+// Hit reports whether the value was cached, shared whether it was loaded by another caller.
+func (c *LRU[K, V]) GetOrLoad(key K, load func() (K, V, error)) (value V, hit, shared bool, err error) {
+	c.mu.Lock()
+	if value, ok := c.get(key); ok {
+		c.mu.Unlock()
+		return value, true, false, nil
+	}
+
+	if cl, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		cl.wg.Wait()
+		return cl.value, false, true, cl.err
+	}
+
+	cl := &call[V]{}
+	cl.wg.Add(1)
+	c.calls[key] = cl
+	c.mu.Unlock()
+
+	cacheKey := key
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		if cl.err == nil {
+			c.add(cacheKey, cl.value)
+		}
+		c.mu.Unlock()
+		cl.wg.Done()
+	}()
+
+	cl.err = errPanicked // Unless load returns.
+	cacheKey, cl.value, cl.err = load()
+	return cl.value, false, false, cl.err
+}
+
+// Len returns the number of cached entries.
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+// Bytes returns the size of the cached entries.
+func (c *LRU[K, V]) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
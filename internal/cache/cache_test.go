@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU_entries(t *testing.T) {
+	c := New[string, int](2, 0, nil)
+	c.Add("a", 1)
+	c.Add("b", 2)
+
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	// b is the least recently used
+	c.Add("c", 3)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	c.Add("a", 4)
+	v, _ = c.Get("a")
+	assert.Equal(t, 4, v)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestLRU_bytes(t *testing.T) {
+	c := New(0, 10, func(k string, v string) int64 { return int64(len(k) + len(v)) })
+	c.Add("a", "1234")
+	c.Add("b", "1234")
+	assert.Equal(t, int64(10), c.Bytes())
+
+	c.Add("c", "1")
+	assert.Equal(t, int64(7), c.Bytes())
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+
+	// Larger than the bound
+	c.Add("d", "12345678901")
+	_, ok = c.Get("d")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	c.Add("b", "1")
+	assert.Equal(t, int64(4), c.Bytes())
+}
+
+func TestLRU_GetOrLoad(t *testing.T) {
+	c := New[string, int](10, 0, nil)
+
+	var loads int32
+	release := make(chan struct{})
+	load := func() (string, int, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "a", 42, nil
+	}
+
+	// Concurrent loads are coalesced
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _, _, err := c.GetOrLoad("a", load)
+			assert.NoError(t, err)
+			assert.Equal(t, 42, v)
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.calls) == 1 && atomic.LoadInt32(&loads) == 1
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	v, hit, sh, err := c.GetOrLoad("a", load)
+	assert.NoError(t, err)
+	assert.True(t, hit)
+	assert.False(t, sh)
+	assert.Equal(t, 42, v)
+
+	// Cached by another key
+	v, _, _, err = c.GetOrLoad("b1", func() (string, int, error) { return "b", 1, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v)
+	_, ok := c.Get("b1")
+	assert.False(t, ok)
+	v, _ = c.Get("b")
+	assert.Equal(t, 1, v)
+
+	// Errors are not cached
+	_, _, _, err = c.GetOrLoad("c", func() (string, int, error) { return "c", 0, errors.New("failed") })
+	assert.Equal(t, "failed", err.Error())
+	_, ok = c.Get("c")
+	assert.False(t, ok)
+	assert.Empty(t, c.calls)
+}
@@ -0,0 +1,351 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const binHeaderSize = 64
+
+// binDB is an IP2Location database in BIN format read into memory.
+// All offsets of the format are 1-based, except string pointers.
+type binDB struct {
+	data []byte
+
+	dbType    uint8
+	dbColumn  uint8
+	year      uint8
+	month     uint8
+	day       uint8
+	ipv4Count uint32
+	ipv4Addr  uint32
+	ipv6Count uint32
+	ipv6Addr  uint32
+	ipv4Index uint32
+	ipv6Index uint32
+}
+
+// isBIN reports whether the IP2Location database code is a BIN distribution.
+func isBIN(code string) bool {
+	return strings.Contains(code, "BIN")
+}
+
+// readBIN reads the BIN database of size bytes from r.
+func readBIN(r io.Reader, size int64) (*binDB, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseBIN(data)
+}
+
+// readAll reads r of size bytes in one allocation.
+func readAll(r io.Reader, size int64) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, size+bytes.MinRead))
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// parseBIN parses the header of the BIN database.
+func parseBIN(data []byte) (*binDB, error) {
+	if len(data) < binHeaderSize {
+		return nil, errors.New("BIN file is too short")
+	}
+
+	b := &binDB{
+		data:      data,
+		dbType:    data[0],
+		dbColumn:  data[1],
+		year:      data[2],
+		month:     data[3],
+		day:       data[4],
+		ipv4Count: binary.LittleEndian.Uint32(data[5:]),
+		ipv4Addr:  binary.LittleEndian.Uint32(data[9:]),
+		ipv6Count: binary.LittleEndian.Uint32(data[13:]),
+		ipv6Addr:  binary.LittleEndian.Uint32(data[17:]),
+		ipv4Index: binary.LittleEndian.Uint32(data[21:]),
+		ipv6Index: binary.LittleEndian.Uint32(data[25:]),
+	}
+
+	// Product code 1 is IP2Location, older databases have no product code.
+	if productCode := data[29]; productCode != 1 && !(productCode == 0 && b.year <= 20) {
+		return nil, errors.New("incorrect IP2Location BIN file format")
+	}
+
+	if b.dbType == 0 || int(b.dbType) >= len(schemas) || int(b.dbColumn) < len(schemas[b.dbType]) {
+		return nil, fmt.Errorf("unsupported BIN database type %d with %d columns", b.dbType, b.dbColumn)
+	}
+
+	if err := b.check(b.ipv4Addr, b.ipv4Count, 4); err != nil {
+		return nil, fmt.Errorf("IPv4 table: %v", err)
+	}
+
+	if err := b.check(b.ipv6Addr, b.ipv6Count, 16); err != nil {
+		return nil, fmt.Errorf("IPv6 table: %v", err)
+	}
+
+	return b, nil
+}
+
+// check that a table of count rows (plus the closing row) at addr fits into data.
+func (b *binDB) check(addr, count uint32, ipSize int) error {
+	if count == 0 {
+		return nil
+	}
+
+	end := int64(addr) - 1 + (int64(count)+1)*int64(b.rowSize(ipSize))
+	if addr == 0 || end > int64(len(b.data)) {
+		return fmt.Errorf("%d rows at %d exceed file size %d", count, addr, len(b.data))
+	}
+
+	return nil
+}
+
+// rowSize returns the size of a row whose IP number is ipSize bytes long.
+func (b *binDB) rowSize(ipSize int) int {
+	return ipSize + (int(b.dbColumn)-1)*4
+}
+
+// search location by num.
+func (b *binDB) search(num Uint128) (Loc, error) {
+	var (
+		first, last Uint128
+		row         int
+		ok          bool
+	)
+
+	if num.Hi == 0 && num.Lo>>32 == 0xffff {
+		first, last, row, ok = b.search4(uint32(num.Lo))
+	} else {
+		first, last, row, ok = b.search6(num)
+	}
+
+	if !ok {
+		return Loc{}, fmt.Errorf("%v not found", num)
+	}
+
+	return Loc{First: first, Last: last, Properties: b.properties(row)}, nil
+}
+
+// search4 returns the range of the IPv4 table containing ip and the offset of its columns.
+func (b *binDB) search4(ip uint32) (first, last Uint128, row int, ok bool) {
+	if b.ipv4Count == 0 {
+		return
+	}
+
+	// The closing row has no successor.
+	if ip == math.MaxUint32 {
+		ip--
+	}
+
+	low, high := 0, int(b.ipv4Count)-1
+	if b.ipv4Index > 0 {
+		pos := int(b.ipv4Index) + int(ip>>16)<<3
+		low, high = int(b.uint32(pos)), int(b.uint32(pos+4))
+	}
+
+	if high >= int(b.ipv4Count) {
+		high = int(b.ipv4Count) - 1
+	}
+
+	size := b.rowSize(4)
+	for low <= high {
+		mid := (low + high) / 2
+		offset := int(b.ipv4Addr) + mid*size
+		from, to := b.uint32(offset), b.uint32(offset+size)
+
+		switch {
+		case ip < from:
+			high = mid - 1
+		case ip >= to:
+			low = mid + 1
+		default:
+			first = Uint128{Lo: 0xffff<<32 | uint64(from)}
+			last = Uint128{Lo: 0xffff<<32 | uint64(to-1)}
+			return first, last, offset + 4, true
+		}
+	}
+
+	return
+}
+
+// search6 returns the range of the IPv6 table containing num and the offset of its columns.
+func (b *binDB) search6(num Uint128) (first, last Uint128, row int, ok bool) {
+	if b.ipv6Count == 0 {
+		return
+	}
+
+	low, high := 0, int(b.ipv6Count)-1
+	if b.ipv6Index > 0 {
+		pos := int(b.ipv6Index) + int(num.Hi>>48)<<3
+		low, high = int(b.uint32(pos)), int(b.uint32(pos+4))
+	}
+
+	if high >= int(b.ipv6Count) {
+		high = int(b.ipv6Count) - 1
+	}
+
+	size := b.rowSize(16)
+	for low <= high {
+		mid := (low + high) / 2
+		offset := int(b.ipv6Addr) + mid*size
+		from, to := b.uint128(offset), b.uint128(offset+size)
+
+		switch {
+		case num.cmp(from) < 0:
+			high = mid - 1
+		case num.cmp(to) >= 0:
+			low = mid + 1
+		default:
+			return from, to.sub(1), offset + 16, true
+		}
+	}
+
+	return
+}
+
+// ranges calls fn for every row in ascending order until fn returns an error.
+// Rows of the IPv6 table within ::ffff:0:0/96 are replaced by the rows of the IPv4 table.
+func (b *binDB) ranges(fn func(first, last Uint128, p map[Properties]string) error) error {
+	v4First, v4Last := Uint128{Lo: 0xffff << 32}, Uint128{Lo: 0xffff<<32 | math.MaxUint32}
+
+	ranges4 := func() error {
+		size := b.rowSize(4)
+		for i := 0; i < int(b.ipv4Count); i++ {
+			offset := int(b.ipv4Addr) + i*size
+			from, to := b.uint32(offset), b.uint32(offset+size)
+
+			// The last row includes the closing IP number, as search4 does.
+			last := uint64(to) - 1
+			if to == math.MaxUint32 {
+				last = math.MaxUint32
+			}
+
+			if err := fn(Uint128{Lo: 0xffff<<32 | uint64(from)}, Uint128{Lo: 0xffff<<32 | last}, b.properties(offset+4)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	done4, size := false, b.rowSize(16)
+	for i := 0; i < int(b.ipv6Count); i++ {
+		offset := int(b.ipv6Addr) + i*size
+		from, last := b.uint128(offset), b.uint128(offset+size).sub(1)
+
+		if from.cmp(v4First) < 0 {
+			end := last
+			if end.cmp(v4First) >= 0 {
+				end = v4First.sub(1)
+			}
+
+			if err := fn(from, end, b.properties(offset+16)); err != nil {
+				return err
+			}
+		}
+
+		if !done4 && last.cmp(v4First) >= 0 {
+			if err := ranges4(); err != nil {
+				return err
+			}
+			done4 = true
+		}
+
+		if last.cmp(v4Last) > 0 {
+			start := from
+			if start.cmp(v4Last) <= 0 {
+				start = v4Last.add(1)
+			}
+
+			if err := fn(start, last, b.properties(offset+16)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !done4 {
+		return ranges4()
+	}
+
+	return nil
+}
+
+// properties reads the columns of the row at offset.
+func (b *binDB) properties(offset int) map[Properties]string {
+	columns := schemas[b.dbType]
+	p := make(map[Properties]string, len(columns))
+
+	// Code and Country share the first column.
+	ptr := int(b.uint32(offset))
+	p[Code] = b.string(ptr)
+	p[Country] = b.string(ptr + 3)
+
+	for i, prop := range columns[2:] {
+		c := offset + (i+1)*4
+		switch prop {
+		case Latitude, Longitude:
+			p[prop] = formatCoordinate(math.Float32frombits(b.uint32(c)))
+		default:
+			p[prop] = b.string(int(b.uint32(c)))
+		}
+	}
+
+	return p
+}
+
+// uint32 reads a little-endian uint32 at 1-based pos.
+func (b *binDB) uint32(pos int) uint32 {
+	if pos < 1 || pos+3 > len(b.data) {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(b.data[pos-1:])
+}
+
+// Uint128 reads a little-endian 128-bit number at 1-based pos.
+func (b *binDB) uint128(pos int) Uint128 {
+	if pos < 1 || pos+15 > len(b.data) {
+		return Uint128{}
+	}
+
+	return Uint128{
+		Hi: binary.LittleEndian.Uint64(b.data[pos+7:]),
+		Lo: binary.LittleEndian.Uint64(b.data[pos-1:]),
+	}
+}
+
+// string reads a length-prefixed string at 0-based pos.
+func (b *binDB) string(pos int) string {
+	if pos < 0 || pos >= len(b.data) {
+		return ""
+	}
+
+	end := pos + 1 + int(b.data[pos])
+	if end > len(b.data) {
+		return ""
+	}
+
+	return string(b.data[pos+1 : end])
+}
+
+// formatCoordinate formats a coordinate with 6 decimals like the CSV distribution does.
+func formatCoordinate(f float32) string {
+	return strconv.FormatFloat(float64(f), 'f', 6, 32)
+}
+
+// String returns a string representation of the binDB struct.
+func (b *binDB) String() string {
+	return fmt.Sprintf("BIN{type: DB%d, date: 20%02d-%02d-%02d, ipv4: %d, ipv6: %d}",
+		b.dbType, b.year, b.month, b.day, b.ipv4Count, b.ipv6Count)
+}
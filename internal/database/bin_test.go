@@ -0,0 +1,273 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeBIN converts the ranges of idx to a database of dbType in BIN format.
+func writeBIN(idx *index, dbType uint8, withIndex bool) []byte {
+	columns := len(schemas[dbType])
+
+	type row struct {
+		first Uint128
+		tuple map[Properties]string
+	}
+
+	var rows4, rows6 []row
+	for i := range idx.starts {
+		tuple := map[Properties]string{}
+		for _, c := range schemas[dbType] {
+			tuple[c] = "-"
+		}
+		if idx.locs[i] != noLoc {
+			tuple = idx.tuples[idx.locs[i]]
+		}
+
+		if r := (row{idx.starts[i], tuple}); r.first.Hi == 0 && r.first.Lo>>32 == 0xffff {
+			rows4 = append(rows4, r)
+		} else {
+			rows6 = append(rows6, r)
+		}
+	}
+
+	v4Close := uint32(idx.last.Lo) + 1
+	if len(rows6) > 0 {
+		v4Close = math.MaxUint32
+	}
+
+	indexSize := 0
+	if withIndex {
+		indexSize = 65536 * 8
+	}
+
+	size4, size6 := 4+(columns-1)*4, 16+(columns-1)*4
+	ipv4Index, ipv6Index := binHeaderSize+1, binHeaderSize+1+indexSize
+	ipv4Addr := binHeaderSize + 1 + 2*indexSize
+	ipv6Addr := ipv4Addr + (len(rows4)+1)*size4
+	stringsAddr := ipv6Addr + (len(rows6)+1)*size6 - 1
+
+	data := make([]byte, stringsAddr)
+	data[0], data[1], data[2], data[3], data[4], data[29] = dbType, byte(columns), 23, 2, 17, 1
+	binary.LittleEndian.PutUint32(data[5:], uint32(len(rows4)))
+	binary.LittleEndian.PutUint32(data[9:], uint32(ipv4Addr))
+	binary.LittleEndian.PutUint32(data[13:], uint32(len(rows6)))
+	binary.LittleEndian.PutUint32(data[17:], uint32(ipv6Addr))
+	if withIndex {
+		binary.LittleEndian.PutUint32(data[21:], uint32(ipv4Index))
+		binary.LittleEndian.PutUint32(data[25:], uint32(ipv6Index))
+	}
+
+	var strs bytes.Buffer
+	offsets := map[string]int{}
+	str := func(s ...string) uint32 {
+		key := s[0]
+		if len(s) > 1 {
+			key = s[0] + "\x00" + s[1]
+		}
+
+		if off, ok := offsets[key]; ok {
+			return uint32(off)
+		}
+
+		off := stringsAddr + strs.Len()
+		for _, v := range s {
+			strs.WriteByte(byte(len(v)))
+			strs.WriteString(v)
+		}
+
+		offsets[key] = off
+		return uint32(off)
+	}
+
+	coordinate := func(s string) uint32 {
+		f, _ := strconv.ParseFloat(s, 32)
+		return math.Float32bits(float32(f))
+	}
+
+	putColumns := func(pos int, t map[Properties]string) {
+		binary.LittleEndian.PutUint32(data[pos-1:], str(t[Code], t[Country]))
+		for i, c := range schemas[dbType][2:] {
+			v := str(t[c])
+			if c == Latitude || c == Longitude {
+				v = coordinate(t[c])
+			}
+			binary.LittleEndian.PutUint32(data[pos+3+i*4:], v)
+		}
+	}
+
+	for i, r := range rows4 {
+		pos := ipv4Addr + i*size4
+		binary.LittleEndian.PutUint32(data[pos-1:], uint32(r.first.Lo))
+		putColumns(pos+4, r.tuple)
+	}
+	binary.LittleEndian.PutUint32(data[ipv4Addr+len(rows4)*size4-1:], v4Close)
+
+	for i, r := range rows6 {
+		pos := ipv6Addr + i*size6
+		binary.LittleEndian.PutUint64(data[pos-1:], r.first.Lo)
+		binary.LittleEndian.PutUint64(data[pos+7:], r.first.Hi)
+		putColumns(pos+16, r.tuple)
+	}
+	closing := idx.last.add(1)
+	binary.LittleEndian.PutUint64(data[ipv6Addr+len(rows6)*size6-1:], closing.Lo)
+	binary.LittleEndian.PutUint64(data[ipv6Addr+len(rows6)*size6+7:], closing.Hi)
+
+	if withIndex {
+		// containing returns the last row whose key is not greater than prefix.
+		containing := func(n int, key func(i int) uint64, prefix uint64) uint32 {
+			i := 0
+			for i+1 < n && key(i+1) <= prefix {
+				i++
+			}
+			return uint32(i)
+		}
+
+		for p := uint64(0); p < 65536; p++ {
+			key4 := func(i int) uint64 { return rows4[i].first.Lo & 0xffffffff }
+			binary.LittleEndian.PutUint32(data[ipv4Index-1+int(p)*8:], containing(len(rows4), key4, p<<16))
+			binary.LittleEndian.PutUint32(data[ipv4Index+3+int(p)*8:], containing(len(rows4), key4, p<<16|0xffff))
+
+			// Keys of IPv6 rows are truncated to the prefix, so the low row may be too early, which is harmless.
+			key6 := func(i int) uint64 { return rows6[i].first.Hi >> 48 }
+			low := uint32(0)
+			if p > 0 {
+				low = containing(len(rows6), key6, p-1)
+			}
+			binary.LittleEndian.PutUint32(data[ipv6Index-1+int(p)*8:], low)
+			binary.LittleEndian.PutUint32(data[ipv6Index+3+int(p)*8:], containing(len(rows6), key6, p))
+		}
+	}
+
+	return append(data, strs.Bytes()...)
+}
+
+func testBIN(t *testing.T, withIndex bool) *binDB {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	b, err := parseBIN(writeBIN(idx, 11, withIndex))
+	assert.NoError(t, err)
+
+	return b
+}
+
+func Test_parseBIN(t *testing.T) {
+	b := testBIN(t, true)
+	assert.Equal(t, "BIN{type: DB11, date: 2023-02-17, ipv4: 11, ipv6: 10}", b.String())
+
+	// Errors
+	_, err := parseBIN([]byte{11, 8})
+	assert.Equal(t, "BIN file is too short", err.Error())
+
+	data := append([]byte{}, b.data...)
+	data[29] = 2
+	_, err = parseBIN(data)
+	assert.Equal(t, "incorrect IP2Location BIN file format", err.Error())
+
+	data = append([]byte{}, b.data...)
+	data[0] = 27
+	_, err = parseBIN(data)
+	assert.Equal(t, "unsupported BIN database type 27 with 8 columns", err.Error())
+
+	_, err = parseBIN(b.data[:b.ipv6Addr])
+	assert.Contains(t, err.Error(), "IPv6 table: 10 rows at")
+
+	_, err = readBIN(iotest.ErrReader(errors.New("read error")), 0)
+	assert.Equal(t, "read error", err.Error())
+
+	_, err = readBIN(bytes.NewReader(b.data[:10]), 10)
+	assert.Equal(t, "BIN file is too short", err.Error())
+}
+
+func Test_binDB_search(t *testing.T) {
+	for _, withIndex := range []bool{true, false} {
+		b := testBIN(t, withIndex)
+
+		// IPv4
+		n, _ := convertIP("8.8.8.8")
+		loc, err := b.search(n)
+		assert.NoError(t, err)
+		assert.Equal(t, big2uint128("281470816487424"), loc.First)
+		assert.Equal(t, big2uint128("281470816487679"), loc.Last)
+		assert.Equal(t, "US", loc.Properties[Code])
+		assert.Equal(t, "United States of America", loc.Properties[Country])
+		assert.Equal(t, "California", loc.Properties[Region])
+		assert.Equal(t, "Mountain View", loc.Properties[City])
+		// Coordinates are stored as float32
+		assert.Equal(t, "37.405991", loc.Properties[Latitude])
+		assert.Equal(t, "-122.078514", loc.Properties[Longitude])
+		assert.Equal(t, "94043", loc.Properties[ZipCode])
+		assert.Equal(t, "-07:00", loc.Properties[TimeZone])
+
+		// IPv6
+		n, _ = convertIP("2001:4860:4860:0:0:0:0:8888")
+		loc, err = b.search(n)
+		assert.NoError(t, err)
+		assert.Equal(t, "GB", loc.Properties[Code])
+		assert.Equal(t, "United Kingdom of Great Britain and Northern Ireland", loc.Properties[Country])
+		assert.Equal(t, "England", loc.Properties[Region])
+		assert.Equal(t, "Upper Clapton", loc.Properties[City])
+		assert.Equal(t, "51.563999", loc.Properties[Latitude])
+		assert.Equal(t, "-0.058080", loc.Properties[Longitude])
+		assert.Equal(t, "E5", loc.Properties[ZipCode])
+		assert.Equal(t, "+01:00", loc.Properties[TimeZone])
+
+		// Last range
+		loc, err = b.search(big2uint128("42541957369031178684983608137712926719"))
+		assert.NoError(t, err)
+		assert.Equal(t, "Monroe", loc.Properties[City])
+
+		// Not found
+		for _, s := range []string{"281470816482303", "42541957369031178684983608137712926720", "1"} {
+			loc, err = b.search(big2uint128(s))
+			assert.Equal(t, Loc{}, loc)
+			assert.Equal(t, s+" not found", err.Error())
+		}
+	}
+}
+
+func Test_binDB_properties(t *testing.T) {
+	b := testBIN(t, true)
+
+	// DB1 has a country only
+	b.dbType = 1
+	n, _ := convertIP("8.8.8.8")
+	loc, err := b.search(n)
+	assert.NoError(t, err)
+	assert.Equal(t, map[Properties]string{Code: "US", Country: "United States of America"}, loc.Properties)
+
+	// Columns are read by the schema of the database type
+	ib := newIndexBuilder(schemas[13])
+	assert.NoError(t, ib.readCSV(strings.NewReader(
+		`"281470816487424","281470816487679","US","United States of America","California","Mountain View","37.405992","-122.078515","-07:00","T1"`)))
+	idx, err := ib.build()
+	assert.NoError(t, err)
+
+	b, err = parseBIN(writeBIN(idx, 13, false))
+	assert.NoError(t, err)
+	loc, err = b.search(n)
+	assert.NoError(t, err)
+	assert.Equal(t, map[Properties]string{Code: "US", Country: "United States of America", Region: "California",
+		City: "Mountain View", Latitude: "37.405991", Longitude: "-122.078514", TimeZone: "-07:00", NetSpeed: "T1"},
+		loc.Properties)
+
+	// Too few columns for the database type
+	data := append([]byte{}, b.data...)
+	data[1] = 7
+	_, err = parseBIN(data)
+	assert.Equal(t, "unsupported BIN database type 13 with 7 columns", err.Error())
+}
+
+func Test_isBIN(t *testing.T) {
+	assert.True(t, isBIN("DB11LITEBINIPV6"))
+	assert.False(t, isBIN("DB11LITEIPV6"))
+}
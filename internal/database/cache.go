@@ -0,0 +1,108 @@
+package database
+
+import (
+	"net/netip"
+
+	"github.com/ivanglie/iploc/internal/cache"
+)
+
+const (
+	// cacheEntryBytes is the estimated size of a cache entry without its properties: the address,
+	// the range, the network and the bookkeeping of the cache.
+	cacheEntryBytes = 200
+
+	// cachePropertyBytes is the estimated size of a property in the map of a cache entry, without its value.
+	cachePropertyBytes = 32
+
+	// Lookups of addresses in a block (/24 for IPv4, /64 for IPv6) within one range are cached by the block.
+	cacheBlockBits4 = 96 + 24
+	cacheBlockBits6 = 64
+)
+
+// CacheStats are the counters of the cache of lookups.
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`     // Estimated size of the entries.
+	Hits      uint64 `json:"hits"`      // Lookups of cached addresses.
+	Misses    uint64 `json:"misses"`    // Lookups of the dataset.
+	Coalesced uint64 `json:"coalesced"` // Lookups waiting for a concurrent lookup of the same address.
+}
+
+// newCache returns the cache of lookups of a dataset by the bounds of db, or nil if the cache is disabled.
+func (db *DB) newCache() *cache.LRU[netip.Prefix, Loc] {
+	if db.CacheEntries <= 0 && db.CacheBytes <= 0 {
+		return nil
+	}
+
+	return cache.New(db.CacheEntries, db.CacheBytes, cacheSize)
+}
+
+// cacheSize estimates the memory of a cache entry. Properties shared with the CSV index are counted too.
+func cacheSize(key netip.Prefix, loc Loc) int64 {
+	n := int64(cacheEntryBytes)
+	for _, v := range loc.Properties {
+		n += cachePropertyBytes + int64(len(v))
+	}
+
+	return n
+}
+
+// cachedLookup looks addr up in the cache of ds, or in ds on a miss, coalescing concurrent lookups of the same address.
+// A location is cached by the block of addr if its range covers the block, and by addr otherwise.
+// IPv4 addresses and IPv4-mapped IPv6 addresses share their entries, zones are ignored.
+func (db *DB) cachedLookup(ds *dataset, addr netip.Addr) (Loc, error) {
+	a := netip.AddrFrom16(addr.As16())
+	bits := cacheBlockBits6
+	if a.Is4In6() {
+		bits = cacheBlockBits4
+	}
+	block := netip.PrefixFrom(a, bits).Masked()
+
+	if loc, ok := ds.cache.Get(block); ok {
+		db.cacheHits.Add(1)
+		return loc, nil
+	}
+
+	key := netip.PrefixFrom(a, 128)
+	loc, hit, shared, err := ds.cache.GetOrLoad(key, func() (netip.Prefix, Loc, error) {
+		loc, err := ds.lookup(addr)
+		if err == nil && prefixBits(loc.Network) <= bits {
+			return block, loc, nil
+		}
+		return key, loc, err
+	})
+	switch {
+	case hit:
+		db.cacheHits.Add(1)
+	case shared:
+		db.cacheCoalesced.Add(1)
+	default:
+		db.cacheMisses.Add(1)
+	}
+
+	return loc, err
+}
+
+// prefixBits returns the bits of p in the IPv6 address space, where IPv4 addresses are mapped into ::ffff:0:0/96.
+func prefixBits(p netip.Prefix) int {
+	if p.Addr().Is4() {
+		return 96 + p.Bits()
+	}
+
+	return p.Bits()
+}
+
+// CacheStats returns the counters of the cache of lookups since the database was created and the size of the cache
+// of the dataset, or nil if the cache is disabled.
+func (db *DB) CacheStats() *CacheStats {
+	if db.CacheEntries <= 0 && db.CacheBytes <= 0 {
+		return nil
+	}
+
+	st := &CacheStats{Hits: db.cacheHits.Load(), Misses: db.cacheMisses.Load(), Coalesced: db.cacheCoalesced.Load()}
+	if ds := db.data.Load(); ds != nil && ds.cache != nil {
+		st.Entries, st.Bytes = ds.cache.Len(), ds.cache.Bytes()
+	}
+
+	return st
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_cachedLookup(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	db := &DB{CacheEntries: 10}
+	db.data.Store(&dataset{src: idx, cache: db.newCache()})
+
+	lookup := func(s string) Loc {
+		loc, err := db.Lookup(netip.MustParseAddr(s))
+		assert.NoError(t, err)
+		return loc
+	}
+
+	loc := lookup("8.8.8.8")
+	assert.Equal(t, "Mountain View", loc.Properties[City])
+	assert.Equal(t, &CacheStats{Entries: 1, Bytes: cacheSize(netip.Prefix{}, loc), Misses: 1}, db.CacheStats())
+
+	// Addresses of a block within a range share the entry of the block
+	assert.Equal(t, loc, lookup("8.8.8.9"))
+	assert.Equal(t, loc, lookup("::ffff:8.8.8.8"))
+	assert.Equal(t, uint64(2), db.CacheStats().Hits)
+
+	assert.Equal(t, "Upper Clapton", lookup("2001:4860:4860::8888").Properties[City])
+	assert.Equal(t, "Upper Clapton", lookup("2001:4860:4860::1").Properties[City])
+
+	// Blocks of other ranges have their own entries
+	c := lookup("2001:4860:7:70d::1")
+	assert.Equal(t, "UY", c.Properties[Code])
+	assert.Equal(t, c, lookup("2001:4860:7:70d::1"))
+	assert.Equal(t, &CacheStats{Entries: 3, Bytes: db.CacheStats().Bytes, Hits: 4, Misses: 3}, db.CacheStats())
+
+	// Errors are not cached
+	_, err = db.Lookup(netip.MustParseAddr("9.9.9.9"))
+	assert.Error(t, err)
+	_, err = db.Lookup(netip.MustParseAddr("9.9.9.9"))
+	assert.Error(t, err)
+	assert.Equal(t, 3, db.CacheStats().Entries)
+	assert.Equal(t, uint64(5), db.CacheStats().Misses)
+
+	// Concurrent lookups
+	st := db.CacheStats()
+	before := st.Hits + st.Misses + st.Coalesced
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "Dallas", lookup("8.8.7.7").Properties[City])
+		}()
+	}
+	wg.Wait()
+	st = db.CacheStats()
+	assert.Equal(t, uint64(100), st.Hits+st.Misses+st.Coalesced-before)
+	assert.Equal(t, 4, st.Entries)
+
+	// Disabled
+	assert.Nil(t, (&DB{}).CacheStats())
+}
+
+func TestDB_cachedLookup_Address(t *testing.T) {
+	// 8.8.8.0/25 and 8.8.8.128/25
+	csv := filepath.Join(t.TempDir(), "DB.CSV")
+	assert.NoError(t, os.WriteFile(csv, []byte(
+		`"281470816487424","281470816487551","US","United States of America","California","Mountain View","37.405992","-122.078515","94043","-07:00"`+"\n"+
+			`"281470816487552","281470816487679","US","United States of America","Texas","Dallas","32.783060","-96.806670","75201","-05:00"`+"\n"),
+		0644))
+
+	idx, err := loadIndex(schemas[11], csv)
+	assert.NoError(t, err)
+
+	db := &DB{CacheEntries: 10}
+	db.data.Store(&dataset{src: idx, cache: db.newCache()})
+
+	// Ranges smaller than a block are cached by address
+	for _, s := range []string{"8.8.8.8", "8.8.8.9", "8.8.8.200", "8.8.8.8"} {
+		_, err = db.Lookup(netip.MustParseAddr(s))
+		assert.NoError(t, err)
+	}
+	st := db.CacheStats()
+	assert.Equal(t, 3, st.Entries)
+	assert.Equal(t, uint64(1), st.Hits)
+}
+
+func TestDB_cachedLookup_Reload(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, zipFileName)
+
+	db := NewDB()
+	db.CacheEntries = 100
+	db.downloadFunc = sampleVersions(archive)
+	assert.NoError(t, db.Init("", "token", dir))
+
+	_, err := db.Search("8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, db.Info().Cache.Entries)
+
+	// Reloads invalidate the cache, counters are kept
+	assert.NoError(t, db.Reload())
+	assert.Equal(t, &CacheStats{Misses: 1}, db.Info().Cache)
+}
@@ -1,163 +1,430 @@
 package database
 
 import (
+	"errors"
 	"fmt"
-	"io"
-	"io/fs"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/ivanglie/iploc/internal/utils"
+	"github.com/ivanglie/iploc/internal/cache"
 	"github.com/ivanglie/iploc/pkg/log"
 )
 
 const (
-	baseUrl = "https://www.ip2location.com/download" // IP2Location API Download Link
-	code    = "DB11LITEIPV6"                         // IP2Location IPv4 and IPv6 Database Code
+	baseUrl         = "https://www.ip2location.com/download" // IP2Location API Download Link
+	ip2LocationCode = "DB11LITEIPV6"                         // IP2Location IPv4 and IPv6 Database Code
 
-	zipPath     = "test/data/"
-	zipFileName = "DB.zip"
+	maxMindURL     = "https://download.maxmind.com/app/geoip_download" // MaxMind Download Link
+	maxMindEdition = "GeoLite2-City"                                   // MaxMind City Database Edition
+
+	downloadAttempts = 5           // Attempts of a download failed by transient errors
+	downloadBackoff  = time.Second // Delay before the second attempt, doubled before each next one
+
+	zipFileName     = "DB.zip"
+	binZipFileName  = "DBBIN.zip"
+	mmdbTarFileName = "DBMMDB.tar.gz"
+)
+
+// Database vendors.
+const (
+	IP2Location = "ip2location"
+	MaxMind     = "maxmind"
 )
 
 type httpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-type downloaderFunc func(token, path string) error
+type downloaderFunc func(token, path string) (zip string, err error)
+
+// dataset is a parsed database and the archive it was parsed from.
+type dataset struct {
+	zip      string
+	zipSize  int64
+	modified time.Time // Modification time of the archive.
+	loaded   time.Time
+	snapshot string // ID of the snapshot in the data directory.
+	src      Source
+	reverse  *reverseIndex
+	stats    *Stats
+	cache    *cache.LRU[netip.Prefix, Loc] // Cache of lookups, nil if disabled.
+}
 
 type DB struct {
-	sync.RWMutex
+	// Provider of the database, IP2Location DB11LITEIPV6 in CSV format by default.
+	Provider Provider
 
 	downloadFunc downloaderFunc
 
 	httpClient httpClient
 
-	zip        string
-	zipSize    int64
-	csv        string
-	CSVSize    int64
-	chunks     []string
-	BufferSize int64
+	// Retries of downloads failed by transient errors.
+	attempts int
+	backoff  time.Duration
+
+	// SHA256 (in hex) and Size of downloaded archives are verified when set.
+	SHA256 string
+	Size   int64
+
+	// Log is the logger of loadings, the logger of pkg/log if nil.
+	Log log.Logger
+
+	// Done, when closed, gives up downloads waiting to retry a failed attempt.
+	Done <-chan struct{}
+
+	// KeepSnapshots is the number of snapshots kept in the data directory, 3 if not set.
+	KeepSnapshots int
+
+	// CacheEntries and CacheBytes bound the LRU cache of lookups by address, which is disabled when both are zero.
+	// Every dataset has its own cache, so loading a dataset invalidates the cached lookups.
+	CacheEntries int
+	CacheBytes   int64
+
+	cacheHits, cacheMisses, cacheCoalesced atomic.Uint64
+
+	// Arguments of Init, used by Reload.
+	local       string
+	token, path string
+
+	statsMu       sync.Mutex
+	snapshotStats map[string]*Stats // Stats of snapshots other than the loaded one by ID and SHA-256.
+
+	loading sync.Mutex              // Held while a new dataset is built.
+	data    atomic.Pointer[dataset] // Dataset serving searches.
+
+	state atomic.Int32 // State of the lifecycle.
+	errMu sync.Mutex
+	err   error // Error of the last loading.
 }
 
 func NewDB() *DB {
-	db := &DB{httpClient: &http.Client{}}
+	db := &DB{Provider: &ip2LocationCSV{code: ip2LocationCode, columns: schemas[11]}, httpClient: &http.Client{},
+		attempts: downloadAttempts, backoff: downloadBackoff}
 	db.downloadFunc = db.download
 
 	return db
 }
 
-func (db *DB) Init(local bool, token, path string) (err error) {
-	db.Lock()
-	defer db.Unlock()
+// Init copies the archive local, if set, or downloads the database to path and loads it.
+// Local, token and path are kept for later reloads.
+func (db *DB) Init(local, token, path string) error {
+	db.loading.Lock()
+	defer db.loading.Unlock()
 
-	if local {
-		log.Info("Copy...")
-		db.zip = filepath.Join(path, zipFileName)
-		if err := utils.CopyFile(filepath.Join(zipPath, zipFileName), db.zip); err != nil {
-			return fmt.Errorf("copying: %v", err)
-		}
+	db.local, db.token, db.path = local, token, path
+	return db.track(db.load)
+}
 
-		if db.zipSize, err = utils.FileSize(db.zip); err != nil {
-			return err
-		}
+// Open loads the archive prepared in the data directory path by a previous run, as described by its manifest,
+// without downloading it again. Token and path are kept for later reloads, which download as Init does.
+func (db *DB) Open(token, path string) error {
+	db.loading.Lock()
+	defer db.loading.Unlock()
+
+	db.local, db.token, db.path = "", token, path
+	return db.track(db.openPrepared)
+}
+
+// Use sets the token and the data directory path of later reloads and rollbacks without loading anything.
+func (db *DB) Use(token, path string) {
+	db.loading.Lock()
+	defer db.loading.Unlock()
+
+	db.local, db.token, db.path = "", token, path
+}
 
-		db.CSVSize = db.zipSize
-		log.Info(fmt.Sprintf("Copying completed %v", db))
+// logger returns the logger of the database.
+func (db *DB) logger() log.Logger {
+	if db.Log != nil {
+		return db.Log
+	}
+
+	return log.Default()
+}
+
+// openPrepared parses the archive of the manifest of the data directory. It must be called with db.loading held.
+func (db *DB) openPrepared() error {
+	m, err := ReadManifest(db.path)
+	if err != nil {
+		return err
+	}
+
+	zip, err := m.check(db.path, db.Provider.Name())
+	if err != nil {
+		return err
+	}
+
+	return db.parse(zip, m.Snapshot)
+}
+
+// Reload builds a new dataset the same way as Init and swaps it in once it is complete and valid.
+// The current dataset keeps serving searches meanwhile and when the reload fails.
+func (db *DB) Reload() error {
+	if !db.loading.TryLock() {
+		return errors.New("reload is already in progress")
+	}
+	defer db.loading.Unlock()
+
+	return db.track(db.load)
+}
+
+// load copies or downloads the archive into a new snapshot and parses it, unless it is identical to the active one.
+// It must be called with db.loading held.
+func (db *DB) load() (err error) {
+	var zip string
+
+	if len(db.local) != 0 {
+		zip = db.local
 	} else {
-		log.Info("Download...")
-		if err := db.downloadFunc(token, path); err != nil {
+		db.logger().Info("Download...")
+		zip, err = db.downloadFunc(db.token, db.path)
+		switch {
+		case errors.Is(err, errNotModified):
+			// The active dataset may be older after a rollback, which is kept until a new version.
+			if db.data.Load() != nil || db.openPrepared() == nil {
+				db.logger().Info("Database is up to date")
+				return nil
+			}
+			db.logger().Info("Archive is up to date")
+		case err != nil:
 			return fmt.Errorf("downloading: %v", err)
+		default:
+			db.logger().Info("Download completed")
 		}
-		log.Info("Download completed")
 	}
 
-	log.Info("Unzip...")
-	if len(db.zip) == 0 {
+	if len(zip) == 0 {
 		return fmt.Errorf("empty db.zip")
 	}
 
-	if db.csv, err = utils.UnzipCSV(db.zip); err != nil {
-		return err
+	sum, err := fileSHA256(zip)
+	if err != nil {
+		return fmt.Errorf("reading archive: %v", err)
+	}
+
+	// An archive identical to the active one is loaded from its snapshot instead of being kept again.
+	if m, err := ReadManifest(db.path); err == nil && m.Source == db.Provider.Name() && m.SHA256 == sum {
+		if ds := db.data.Load(); (ds != nil && ds.snapshot == m.Snapshot) || db.openPrepared() == nil {
+			db.logger().Info("Database is up to date")
+			return nil
+		}
+	}
+
+	// Local archives are copied, downloaded ones are linked to stay in the data directory for conditional requests.
+	if len(db.local) != 0 {
+		db.logger().Info("Copy...")
 	}
 
-	if db.CSVSize, err = utils.FileSize(db.csv); err != nil {
+	id, snap, err := newSnapshot(db.path, zip, len(db.local) == 0)
+	if err != nil {
+		return fmt.Errorf("keeping snapshot: %v", err)
+	}
+
+	if len(db.local) != 0 {
+		db.logger().Info("Copying completed")
+	}
+
+	m, err := newManifest(db.path, db.Provider.Name(), id, snap, sum)
+	if err == nil {
+		err = db.parse(snap, id)
+	}
+
+	if err != nil {
+		os.RemoveAll(filepath.Dir(snap))
 		return err
 	}
-	log.Info("Unzip completed")
 
-	log.Info("Split...")
-	k := int64(200)
-	if local {
-		k = 2
+	// The manifest of the snapshot completes it, the one of the data directory activates it.
+	if err = m.write(filepath.Dir(snap)); err == nil {
+		err = m.write(db.path)
+	}
+	if err != nil {
+		return fmt.Errorf("writing manifest: %v", err)
+	}
+
+	keep := db.KeepSnapshots
+	if keep <= 0 {
+		keep = keepSnapshots
 	}
 
-	db.chunks, err = utils.SplitCSV(db.csv, db.CSVSize, db.CSVSize/k)
+	return pruneSnapshots(db.path, keep, db.logger())
+}
+
+// parse builds a new dataset of the archive zip of the snapshot and swaps it in. It must be called with db.loading held.
+func (db *DB) parse(zip, snapshot string) (err error) {
+	db.logger().Info("Parse...")
+	info, err := os.Stat(zip)
 	if err != nil {
-		return fmt.Errorf("splitting: %v", err)
+		return err
+	}
+
+	ds := &dataset{zip: zip, zipSize: info.Size(), modified: info.ModTime(), snapshot: snapshot}
+
+	if ds.src, err = db.Provider.Parse(ds.zip, db.logger()); err != nil {
+		return err
 	}
 
-	log.Info("Split completed")
+	if err = validate(ds.src); err != nil {
+		return fmt.Errorf("validating: %v", err)
+	}
+
+	if ds.reverse, err = newReverseIndex(ds.src); err != nil {
+		return fmt.Errorf("indexing locations: %v", err)
+	}
+
+	if ds.stats, err = newStats(ds.src); err != nil {
+		return fmt.Errorf("counting ranges: %v", err)
+	}
+	ds.stats.Snapshot, ds.stats.Modified = snapshot, ds.modified
+
+	ds.cache = db.newCache()
 
-	return err
+	ds.loaded = time.Now()
+	db.data.Store(ds)
+	db.logger().Info(fmt.Sprintf("Database loaded: %v", db))
+
+	return nil
 }
 
+// errStop stops iterating over ranges.
+var errStop = errors.New("stop")
+
+// validate checks that the source has at least one range with a location.
+func validate(s Source) error {
+	switch err := s.ranges(func(first, last Uint128, p map[Properties]string) error { return errStop }); err {
+	case errStop:
+		return nil
+	case nil:
+		return errors.New("no ranges found")
+	default:
+		return err
+	}
+}
+
+// ErrNotLoaded is returned by searches before a dataset is loaded.
+var ErrNotLoaded = errors.New("index is empty or not loaded")
+
 // Search for a given IP address and return a Loc struct.
 func (db *DB) Search(address string) (*Loc, error) {
-	db.RLock()
-	defer db.RUnlock()
+	if db.data.Load() == nil {
+		return nil, ErrNotLoaded
+	}
+
+	num, err := convertIP(address)
+	if err != nil {
+		return nil, err
+	}
 
-	return search(address, db.chunks)
+	loc, err := db.Lookup(num.Addr())
+	if err != nil {
+		return nil, err
+	}
+
+	return &loc, nil
 }
 
-// download IP2Location database (specified by token) to path.
-func (db *DB) download(token, path string) (err error) {
-	if len(path) == 0 {
-		err = fmt.Errorf("empty path")
-		return
+// Lookup returns the location of addr with the network of its netblock containing addr.
+// IPv4 addresses and IPv4-mapped IPv6 addresses are looked up alike, the zone is ignored.
+// Lookups in the CSV index do not allocate, unless the cache is enabled.
+func (db *DB) Lookup(addr netip.Addr) (Loc, error) {
+	ds := db.data.Load()
+	if ds == nil {
+		return Loc{}, ErrNotLoaded
 	}
 
-	var req *http.Request
-	if req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s?token=%s&file=%s", baseUrl, token, code), nil); err != nil {
-		return
+	if !addr.IsValid() {
+		return Loc{}, errors.New("invalid address")
 	}
 
-	var resp *http.Response
-	if resp, err = db.httpClient.Do(req); err != nil {
-		return
+	if ds.cache != nil {
+		return db.cachedLookup(ds, addr)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("error %d %s", resp.StatusCode, resp.Status)
-		return
+	return ds.lookup(addr)
+}
+
+// lookup returns the location of the valid address addr in the dataset.
+func (ds *dataset) lookup(addr netip.Addr) (Loc, error) {
+	num := uint128FromAddr(addr)
+	loc, err := ds.src.search(num)
+	if err != nil {
+		return Loc{}, err
 	}
 
-	if db.zip, err = filepath.Abs(filepath.Join(filepath.Dir(path), code+".zip")); err != nil {
-		return
+	loc.Network = network(num, loc.First, loc.Last)
+	return loc, nil
+}
+
+// Overlaps calls fn for every range with a location overlapping the addresses from first to last
+// until fn returns an error. Ranges of unknown country ("-") are skipped, as gaps are.
+// Ranges of the CSV index are found by a binary search and passed in ascending order, other sources are scanned.
+func (db *DB) Overlaps(first, last netip.Addr, fn func(loc Loc) error) error {
+	src := db.source()
+	if src == nil {
+		return ErrNotLoaded
 	}
 
-	var file *os.File
-	if file, err = os.OpenFile(db.zip, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fs.ModeAppend); err != nil {
-		return
+	if !first.IsValid() || !last.IsValid() {
+		return errors.New("invalid address")
 	}
-	defer file.Close()
 
-	if _, err = io.Copy(file, resp.Body); err != nil {
-		return
+	f, l := uint128FromAddr(first), uint128FromAddr(last)
+	if f.cmp(l) > 0 {
+		return fmt.Errorf("range from %v to %v is empty", first, last)
 	}
 
-	if db.zipSize, err = utils.FileSize(db.zip); err != nil {
-		return err
+	call := func(a, b Uint128, p map[Properties]string) error {
+		if p[Code] == "-" {
+			return nil
+		}
+
+		return fn(Loc{First: a, Last: b, Properties: p})
+	}
+
+	if idx, ok := src.(*index); ok {
+		return idx.overlaps(f, l, call)
 	}
 
-	return
+	return src.ranges(func(a, b Uint128, p map[Properties]string) error {
+		if a.cmp(l) > 0 || b.cmp(f) < 0 {
+			return nil
+		}
+
+		return call(a, b, p)
+	})
+}
+
+// source returns the source of the current dataset or nil if it is not loaded.
+func (db *DB) source() Source {
+	if ds := db.data.Load(); ds != nil {
+		return ds.src
+	}
+
+	return nil
+}
+
+// download the database of the provider (specified by token or license key) to the directory path.
+func (db *DB) download(token, path string) (zip string, err error) {
+	if len(path) == 0 {
+		err = fmt.Errorf("empty path")
+		return
+	}
+
+	return db.Provider.Fetch(&fetcher{client: db.httpClient, log: db.logger(), sha256: db.SHA256, size: db.Size,
+		attempts: db.attempts, backoff: db.backoff, done: db.Done}, token, path)
 }
 
 // String returns a string representation of the DB struct.
 func (db *DB) String() string {
-	return fmt.Sprintf("DB{zip: %s, zipSize: %d, csv: %s, csvSize: %d, chunks: %v, ChunksCount: %d}",
-		db.zip, db.zipSize, db.csv, db.CSVSize, db.chunks, db.BufferSize)
+	ds := db.data.Load()
+	if ds == nil {
+		ds = &dataset{}
+	}
+
+	return fmt.Sprintf("DB{zip: %s, zipSize: %d, source: %v}", ds.zip, ds.zipSize, ds.src)
 }
@@ -1,17 +1,69 @@
 package database
 
 import (
+	"archive/zip"
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/ivanglie/iploc/internal/utils"
 	"github.com/stretchr/testify/assert"
 )
 
+// sampleVersions returns a downloader of new versions of the sample archive to path.
+// Versions differ by the comment of the archive only.
+func sampleVersions(path string) downloaderFunc {
+	n := 0
+	return func(token, dir string) (string, error) {
+		n++
+		return path, rezip("../../test/data/"+zipFileName, path, strconv.Itoa(n))
+	}
+}
+
+// rezip writes a copy of the zip archive src with the comment to path.
+func rezip(src, path, comment string) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range r.File {
+		if err := w.Copy(f); err != nil {
+			return err
+		}
+	}
+
+	if err := w.SetComment(comment); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return replaceFile(path, buf.Bytes())
+}
+
+// replaceFile writes b to a new file renamed to path, as fetch does, so that snapshots linked to path are kept.
+func replaceFile(path string, b []byte) error {
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
 type mockClient struct{}
 
 func (m *mockClient) Do(req *http.Request) (*http.Response, error) {
@@ -55,17 +107,150 @@ func (m *errorClient) Do(req *http.Request) (*http.Response, error) {
 
 func TestDB_Init(t *testing.T) {
 	db := NewDB()
-	db.downloadFunc = func(url, path string) error { return nil }
-
-	// assert.NoError(t, db.Init(true, "token", "path"))
+	db.downloadFunc = func(url, path string) (string, error) { return "", nil }
+	assert.Equal(t, "empty db.zip", db.Init("", "token", "path").Error())
 
 	// Download error
-	db.downloadFunc = func(url, path string) error { return errors.New("download error") }
-	assert.Error(t, db.Init(true, "token", "path"))
+	db.downloadFunc = func(url, path string) (string, error) { return "", errors.New("download error") }
+	assert.Equal(t, "downloading: download error", db.Init("", "token", "path").Error())
+
+	// Local archive
+	dir := t.TempDir()
+	assert.NoError(t, db.Init("../../test/data/"+zipFileName, "token", dir))
+	assert.Equal(t, "1", db.Info().Dataset.Snapshot)
+
+	// The same archive is copied once, into its snapshot
+	db = NewDB()
+	assert.NoError(t, db.Init("../../test/data/"+zipFileName, "token", dir))
+	assert.Equal(t, "1", db.Info().Dataset.Snapshot)
+
+	_, err := os.Stat(filepath.Join(dir, zipFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	// Copying error
+	assert.Error(t, db.Init("../../test/data/missing.zip", "token", dir))
+}
+
+func TestDB_Reload(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, zipFileName)
+
+	db := NewDB()
+	db.downloadFunc = func(token, path string) (string, error) {
+		return archive, utils.CopyFile("../../test/data/"+zipFileName, archive)
+	}
+	assert.NoError(t, db.Init("", "token", dir))
+	loaded := db.data.Load()
+
+	loc, err := db.Search("8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, "Mountain View", loc.Properties[City])
+
+	// Identical archives are not kept or parsed again
+	assert.NoError(t, db.Reload())
+	assert.Same(t, loaded, db.data.Load())
+
+	db.downloadFunc = sampleVersions(archive)
+	assert.NoError(t, db.Reload())
+	assert.NotSame(t, loaded, db.data.Load())
+	loaded = db.data.Load()
+	assert.Equal(t, "2", loaded.snapshot)
+
+	// Downloaded archives are linked into their snapshots
+	a, err := os.Stat(archive)
+	assert.NoError(t, err)
+	b, err := os.Stat(loaded.zip)
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(a, b))
+
+	// Not modified archives are not parsed again
+	db.downloadFunc = func(token, path string) (string, error) { return archive, errNotModified }
+	assert.NoError(t, db.Reload())
+	assert.Same(t, loaded, db.data.Load())
+
+	// The current dataset keeps serving when the reload fails
+	db.downloadFunc = func(token, path string) (string, error) { return "", errors.New("download error") }
+	assert.Equal(t, "downloading: download error", db.Reload().Error())
+	assert.Same(t, loaded, db.data.Load())
+
+	db.downloadFunc = func(token, path string) (string, error) {
+		return archive, replaceFile(archive, []byte("not a zip"))
+	}
+	assert.Error(t, db.Reload())
+	assert.Same(t, loaded, db.data.Load())
+
+	// Empty databases are discarded
+	db.downloadFunc = func(token, path string) (string, error) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		if _, err := zw.Create("DB.CSV"); err != nil {
+			return "", err
+		}
+		if err := zw.Close(); err != nil {
+			return "", err
+		}
+		return archive, replaceFile(archive, buf.Bytes())
+	}
+	assert.Equal(t, "indexing: no records found", db.Reload().Error())
+	assert.Same(t, loaded, db.data.Load())
+
+	loc, err = db.Search("8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, "Mountain View", loc.Properties[City])
+
+	// Concurrent reload
+	db.loading.Lock()
+	assert.Equal(t, "reload is already in progress", db.Reload().Error())
+	db.loading.Unlock()
+}
+
+func TestDB_Open(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, ip2LocationCode+".zip")
+
+	// No manifest
+	db := NewDB()
+	assert.Error(t, db.Open("token", dir))
+
+	// Prepared by a previous run
+	db.downloadFunc = func(token, path string) (string, error) {
+		return archive, utils.CopyFile("../../test/data/"+zipFileName, archive)
+	}
+	assert.NoError(t, db.Init("", "token", dir))
+
+	db = NewDB()
+	db.downloadFunc = func(token, path string) (string, error) { return "", errors.New("unexpected download") }
+	assert.NoError(t, db.Open("token", dir))
+
+	loc, err := db.Search("8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, "Mountain View", loc.Properties[City])
+
+	// Prepared for another provider
+	db = NewDB()
+	db.Provider = &maxMind{edition: maxMindEdition}
+	assert.Equal(t, "manifest is of IP2Location-DB11LITEIPV6, not MaxMind-GeoLite2-City", db.Open("token", dir).Error())
+
+	// Changed archive
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "snapshots", "1", ip2LocationCode+".zip"), []byte("changed"), 0644))
+	db = NewDB()
+	assert.Contains(t, db.Open("token", dir).Error(), "SHA-256 of snapshots/1/DB11LITEIPV6.zip is ")
+}
+
+func Test_validate(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+	assert.NoError(t, validate(idx))
+
+	assert.Equal(t, "no ranges found", validate(&binDB{dbType: 11}).Error())
 }
 
 func TestDB_Search(t *testing.T) {
-	db := &DB{chunks: []string{"../../test/data/DB_0001.CSV", "../../test/data/DB_0002.CSV", "../../test/data/DB_0003.CSV"}}
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	db := &DB{}
+	db.data.Store(&dataset{src: idx})
 	loc, err := db.Search("8.8.8.8")
 	assert.Nil(t, err)
 	assert.NotNil(t, loc)
@@ -78,37 +263,175 @@ func TestDB_Search(t *testing.T) {
 	assert.Equal(t, "-122.078515", loc.Properties[Longitude])
 	assert.Equal(t, "94043", loc.Properties[ZipCode])
 	assert.Equal(t, "-07:00", loc.Properties[TimeZone])
+
+	// Zones are ignored as by Lookup
+	loc, err = db.Search("2001:4860:4860::8888%eth0")
+	assert.NoError(t, err)
+	assert.Equal(t, "GB", loc.Properties[Code])
+
+	// Errors
+	loc, err = db.Search("8.8.8.")
+	assert.Nil(t, loc)
+	assert.Equal(t, "address 8.8.8. is incorrect IP", err.Error())
+
+	loc, err = db.Search("9.9.9.9")
+	assert.Nil(t, loc)
+	assert.Equal(t, "281470833330441 not found", err.Error())
+
+	db = &DB{}
+	loc, err = db.Search("8.8.8.8")
+	assert.Nil(t, loc)
+	assert.Equal(t, "index is empty or not loaded", err.Error())
+}
+
+func TestDB_Lookup(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	db := &DB{}
+	db.data.Store(&dataset{src: idx})
+
+	// IPv4 and IPv4-mapped IPv6 addresses
+	for _, s := range []string{"8.8.8.8", "::ffff:8.8.8.8"} {
+		loc, err := db.Lookup(netip.MustParseAddr(s))
+		assert.NoError(t, err)
+		assert.Equal(t, "Mountain View", loc.Properties[City])
+		assert.Equal(t, netip.MustParsePrefix("8.8.8.0/24"), loc.Network)
+
+		first, last := loc.Range()
+		assert.Equal(t, netip.MustParseAddr("8.8.8.0"), first)
+		assert.Equal(t, netip.MustParseAddr("8.8.8.255"), last)
+	}
+
+	// IPv6
+	loc, err := db.Lookup(netip.MustParseAddr("2001:4860:4860::8888"))
+	assert.NoError(t, err)
+	assert.Equal(t, "GB", loc.Properties[Code])
+	assert.True(t, loc.Network.Addr().Is6())
+	assert.True(t, loc.Network.Contains(netip.MustParseAddr("2001:4860:4860::8888")))
+
+	// Lookups do not allocate
+	addr := netip.MustParseAddr("8.8.8.8")
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := db.Lookup(addr); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Equal(t, float64(0), allocs)
+
+	// Errors
+	_, err = db.Lookup(netip.Addr{})
+	assert.Equal(t, "invalid address", err.Error())
+
+	_, err = db.Lookup(netip.MustParseAddr("9.9.9.9"))
+	assert.Equal(t, "281470833330441 not found", err.Error())
+
+	_, err = (&DB{}).Lookup(addr)
+	assert.Equal(t, ErrNotLoaded, err)
+}
+
+func TestDB_Overlaps(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	overlaps := func(db *DB, first, last string) (cities []string, err error) {
+		err = db.Overlaps(netip.MustParseAddr(first), netip.MustParseAddr(last), func(loc Loc) error {
+			f, l := loc.Range()
+			cities = append(cities, f.String()+"-"+l.String()+" "+loc.Properties[City])
+			return nil
+		})
+		return
+	}
+
+	// The CSV index and the scan of other sources find the same ranges
+	for _, src := range []Source{idx, testBIN(t, true)} {
+		db := &DB{}
+		db.data.Store(&dataset{src: src})
+
+		cities, err := overlaps(db, "8.8.0.0", "8.8.3.255")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"8.7.247.0-8.8.3.255 Monroe"}, cities)
+
+		cities, err = overlaps(db, "8.8.6.128", "8.8.8.1")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"8.8.6.0-8.8.6.255 Newtown Square", "8.8.7.0-8.8.7.255 Dallas",
+			"8.8.8.0-8.8.8.255 Mountain View"}, cities)
+
+		cities, err = overlaps(db, "2001:4860:4860::", "2001:4868::")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"2001:4860:4860::-2001:4860:4860:0:ffff:ffff:ffff:ffff Upper Clapton",
+			"2001:4860:4860:1::-2001:4860:ffff:ffff:ffff:ffff:ffff:ffff Mountain View",
+			"2001:4868::-2001:4868:ffff:ffff:ffff:ffff:ffff:ffff Ashburn"}, cities)
+
+		cities, err = overlaps(db, "::ffff:8.8.8.8", "::ffff:8.8.8.8")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"8.8.8.0-8.8.8.255 Mountain View"}, cities)
+
+		// Gaps are skipped
+		cities, err = overlaps(db, "8.8.9.128", "8.8.255.255")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"8.8.9.0-8.8.9.255 Fort Lauderdale"}, cities)
+
+		cities, err = overlaps(db, "1.1.1.1", "8.7.243.255")
+		assert.NoError(t, err)
+		assert.Empty(t, cities)
+
+		// Errors
+		_, err = overlaps(db, "8.8.8.8", "8.8.8.7")
+		assert.Equal(t, "range from 8.8.8.8 to 8.8.8.7 is empty", err.Error())
+
+		err = db.Overlaps(netip.Addr{}, netip.MustParseAddr("8.8.8.8"), func(loc Loc) error { return nil })
+		assert.Equal(t, "invalid address", err.Error())
+
+		err = db.Overlaps(netip.MustParseAddr("8.8.0.0"), netip.MustParseAddr("8.8.9.0"), func(loc Loc) error { return errStop })
+		assert.Equal(t, errStop, err)
+	}
+
+	_, err = overlaps(&DB{}, "8.8.8.8", "8.8.8.8")
+	assert.Equal(t, ErrNotLoaded, err)
 }
 
 func TestDB_download(t *testing.T) {
 	db := NewDB()
 	db.httpClient = &mockClient{}
-	err := db.download("token", "../../test/data/")
+	zip, err := db.download("token", "../../test/data/")
+	assert.NoError(t, err)
+	assert.Equal(t, ip2LocationCode+".zip", filepath.Base(zip))
+
+	fi, err := os.Stat(zip)
 	assert.NoError(t, err)
-	assert.Equal(t, code+".zip", filepath.Base(db.zip))
-	assert.Equal(t, int64(1254), db.zipSize)
+	assert.Equal(t, int64(1254), fi.Size())
 
-	os.Remove("../../test/data/" + code + ".zip")
+	os.Remove("../../test/data/" + ip2LocationCode + ".zip")
 
 	// Bad status error
 	db = NewDB()
 	db.httpClient = &badStatusClient{}
-	err = db.download("token", "../../test/data/")
+	db.backoff = time.Millisecond
+	_, err = db.download("token", "../../test/data/")
 	assert.Equal(t, "error 503 Service Unavailable", err.Error())
 
 	// Empty path error
 	db = NewDB()
-	err = db.download("token", "")
+	_, err = db.download("token", "")
 	assert.Equal(t, "empty path", err.Error())
 
 	// Something went wrong error
 	db = NewDB()
 	db.httpClient = &errorClient{}
-	err = db.download("token", "../../test/data/")
+	db.backoff = time.Millisecond
+	_, err = db.download("token", "../../test/data/")
 	assert.Equal(t, "something went wrong", err.Error())
 }
 
 func TestDB_String(t *testing.T) {
-	db := &DB{BufferSize: 2, chunks: []string{"DB_0001.CSV", "DB_0002.CSV"}}
-	assert.Equal(t, "DB{zip: , zipSize: 0, csv: , csvSize: 0, chunks: [DB_0001.CSV DB_0002.CSV], ChunksCount: 2}", db.String())
+	db := &DB{}
+	assert.Equal(t, "DB{zip: , zipSize: 0, source: <nil>}", db.String())
+
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	db = &DB{}
+	db.data.Store(&dataset{src: idx})
+	assert.Equal(t, "DB{zip: , zipSize: 0, source: index{ranges: 21, locations: 16}}", db.String())
 }
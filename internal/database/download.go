@@ -0,0 +1,322 @@
+package database
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+// errNotModified is returned by fetch with the path of the archive when the server has no newer one.
+var errNotModified = errors.New("not modified")
+
+// errCanceled is returned by fetch when the download is given up between attempts.
+var errCanceled = errors.New("download canceled")
+
+// fetcher downloads database archives and verifies them before they replace the previous ones.
+type fetcher struct {
+	client httpClient
+	sha256 string // Expected SHA-256 in hex, optional.
+	size   int64  // Expected size, optional.
+
+	attempts int           // Attempts of a download, 1 if not set.
+	backoff  time.Duration // Delay before the second attempt, doubled before each next one.
+
+	done <-chan struct{} // Closed to give up the download instead of waiting for the next attempt, optional.
+
+	log log.Logger // Logger of retries, the logger of pkg/log if nil.
+}
+
+// transientError is an error of an attempt worth retrying, like a dropped connection or a 5xx response.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+// partial is the state of a download kept between attempts.
+type partial struct {
+	path   string      // Temporary file.
+	size   int64       // Bytes in the temporary file.
+	total  int64       // Size of the archive, -1 if unknown.
+	header http.Header // Header of the response that started the download.
+}
+
+// logger returns the logger of the fetcher.
+func (f *fetcher) logger() log.Logger {
+	if f.log != nil {
+		return f.log
+	}
+
+	return log.Default()
+}
+
+// fetch downloads url to path and returns its absolute path.
+// When an archive exists at path, the request is conditional and errNotModified is returned if the server has no newer one.
+// The download is written to a temporary file, which is renamed to path when it is complete and valid.
+// Attempts failed by transient errors are retried with exponential backoff, resuming the temporary file by a Range request.
+func (f *fetcher) fetch(url, path string) (abs string, err error) {
+	if abs, err = filepath.Abs(path); err != nil {
+		return
+	}
+
+	p := &partial{path: abs + ".tmp", total: -1}
+	defer os.Remove(p.path)
+
+	// A temporary file left by an interrupted process may belong to another version.
+	os.Remove(p.path)
+
+	for attempt := 1; ; attempt++ {
+		err = f.try(url, abs, p)
+
+		var te *transientError
+		if !errors.As(err, &te) || attempt >= f.attempts {
+			break
+		}
+
+		delay := f.backoff << (attempt - 1)
+		f.logger().Info(fmt.Sprintf("Download attempt %d failed: %v, retrying in %v", attempt, err, delay))
+		if !f.wait(delay) {
+			err = errCanceled
+			break
+		}
+	}
+
+	if err != nil {
+		return
+	}
+
+	if err = f.verify(p.path); err != nil {
+		return
+	}
+
+	if err = os.Rename(p.path, abs); err != nil {
+		return
+	}
+
+	if t, parseErr := http.ParseTime(p.header.Get("Last-Modified")); parseErr == nil {
+		os.Chtimes(abs, t, t)
+	}
+
+	if etag := p.header.Get("ETag"); len(etag) != 0 {
+		err = os.WriteFile(abs+".etag", []byte(etag), 0644)
+	} else {
+		os.Remove(abs + ".etag")
+	}
+
+	return
+}
+
+// wait waits for delay and reports whether it passed before the download was given up.
+func (f *fetcher) wait(delay time.Duration) bool {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-f.done:
+		return false
+	}
+}
+
+// try makes an attempt to download url to the temporary file of p, resuming it when it is not empty.
+func (f *fetcher) try(url, abs string, p *partial) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	// Resume only when the server can tell whether the archive changed since.
+	validator := p.header.Get("ETag")
+	if len(validator) == 0 {
+		validator = p.header.Get("Last-Modified")
+	}
+
+	resume := p.size > 0 && len(validator) != 0
+	if resume {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", p.size))
+		req.Header.Set("If-Range", validator)
+	} else if info, statErr := os.Stat(abs); statErr == nil {
+		req.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+		if etag, readErr := os.ReadFile(abs + ".etag"); readErr == nil && len(etag) != 0 {
+			req.Header.Set("If-None-Match", string(etag))
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return &transientError{err}
+	}
+	defer resp.Body.Close()
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && resume:
+		var start, end int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &p.total); err != nil || start != p.size {
+			p.size = 0
+			return &transientError{fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))}
+		}
+	case resp.StatusCode == http.StatusOK:
+		if ct := resp.Header.Get("Content-Type"); strings.HasPrefix(ct, "text/") {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			return fmt.Errorf("unexpected %s response: %q", ct, bytes.TrimSpace(msg))
+		}
+
+		// The whole archive, from scratch.
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		p.size, p.total, p.header = 0, resp.ContentLength, resp.Header
+	case resp.StatusCode == http.StatusNotModified:
+		return errNotModified
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		p.size = 0
+		return &transientError{fmt.Errorf("error %d %s", resp.StatusCode, resp.Status)}
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return &transientError{fmt.Errorf("error %d %s", resp.StatusCode, resp.Status)}
+	default:
+		return fmt.Errorf("error %d %s", resp.StatusCode, resp.Status)
+	}
+
+	file, err := os.OpenFile(p.path, flag, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, resp.Body)
+	p.size += n
+	if err != nil {
+		return &transientError{err}
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if p.total > 0 && p.size != p.total {
+		return &transientError{fmt.Errorf("truncated body: %d of %d bytes", p.size, p.total)}
+	}
+
+	return nil
+}
+
+// verify checks the size, checksum and contents of the archive at path.
+func (f *fetcher) verify(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+
+	if f.size > 0 && n != f.size {
+		return fmt.Errorf("size is %d bytes, expected %d", n, f.size)
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); len(f.sha256) != 0 && !strings.EqualFold(sum, f.sha256) {
+		return fmt.Errorf("SHA-256 is %s, expected %s", sum, f.sha256)
+	}
+
+	return verifyArchive(path)
+}
+
+// verifyArchive checks that the file at path is a complete zip or gzipped tar archive.
+// The contents of zip archives are read to check their CRC-32 checksums, gzip checks its own.
+func verifyArchive(path string) error {
+	head := make([]byte, 512)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return fmt.Errorf("not an archive: %v", err)
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return verifyZip(path)
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return verifyTarGz(file)
+	default:
+		return fmt.Errorf("not an archive: %q", bytes.TrimSpace(head))
+	}
+}
+
+func verifyZip(path string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("corrupted zip: %v", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if err := verifyZipFile(f); err != nil {
+			return fmt.Errorf("corrupted zip: %s: %v", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func verifyZipFile(f *zip.File) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+func verifyTarGz(r io.Reader) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("corrupted gzip: %v", err)
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		if _, err = tr.Next(); err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("corrupted tar: %v", err)
+		}
+	}
+
+	// Read the rest to check the checksum of gzip.
+	if _, err := io.Copy(io.Discard, gr); err != nil {
+		return fmt.Errorf("corrupted gzip: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetcher_fetch(t *testing.T) {
+	archive, err := os.ReadFile("../../test/data/" + zipFileName)
+	assert.NoError(t, err)
+
+	modified := time.Date(2023, 2, 17, 0, 0, 0, 0, time.UTC)
+	var (
+		header http.Header
+		body   = archive
+		ct     = "application/zip"
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", ct)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.Write(body)
+	}))
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), zipFileName)
+	f := &fetcher{client: s.Client()}
+
+	abs, err := f.fetch(s.URL, path)
+	assert.NoError(t, err)
+	assert.Equal(t, path, abs)
+	assert.Empty(t, header.Get("If-Modified-Since"))
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(archive)), info.Size())
+	assert.True(t, modified.Equal(info.ModTime()))
+
+	// Not modified
+	abs, err = f.fetch(s.URL, path)
+	assert.Equal(t, errNotModified, err)
+	assert.Equal(t, path, abs)
+	assert.Equal(t, `"v1"`, header.Get("If-None-Match"))
+	assert.Equal(t, modified.Format(http.TimeFormat), header.Get("If-Modified-Since"))
+
+	// Bad archives do not replace the good one
+	assert.NoError(t, os.Remove(path+".etag"))
+	for _, tc := range []struct {
+		body []byte
+		ct   string
+		f    *fetcher
+		err  string
+	}{
+		{[]byte("NO PERMISSION\n"), "text/plain", f, `unexpected text/plain response: "NO PERMISSION"`},
+		{[]byte("<html>Error</html>"), "application/octet-stream", f, `not an archive: "<html>Error</html>"`},
+		{corrupt(archive), "application/zip", f, "corrupted zip: DB.CSV: zip: checksum error"},
+		{archive, "application/zip", &fetcher{client: s.Client(), size: 1000}, "size is 1254 bytes, expected 1000"},
+		{archive, "application/zip", &fetcher{client: s.Client(), sha256: "00"}, "SHA-256 is " + sum(archive) + ", expected 00"},
+	} {
+		body, ct = tc.body, tc.ct
+		_, err = tc.f.fetch(s.URL, path)
+		assert.Equal(t, tc.err, err.Error())
+
+		b, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, archive, b)
+
+		_, err = os.Stat(path + ".tmp")
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// Expected size and checksum
+	body, ct = archive, "application/zip"
+	_, err = (&fetcher{client: s.Client(), size: 1254, sha256: strings.ToUpper(sum(archive))}).fetch(s.URL, path)
+	assert.NoError(t, err)
+}
+
+func TestFetcher_fetch_truncated(t *testing.T) {
+	archive, err := os.ReadFile("../../test/data/" + zipFileName)
+	assert.NoError(t, err)
+
+	c := clientFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			ContentLength: int64(len(archive)),
+			Body:          io.NopCloser(bytes.NewReader(archive[:1000])),
+		}, nil
+	})
+
+	_, err = (&fetcher{client: c}).fetch("http://localhost", filepath.Join(t.TempDir(), zipFileName))
+	assert.Equal(t, "truncated body: 1000 of 1254 bytes", err.Error())
+}
+
+func TestFetcher_fetch_retry(t *testing.T) {
+	archive, err := os.ReadFile("../../test/data/" + zipFileName)
+	assert.NoError(t, err)
+
+	var (
+		requests []*http.Request
+		etag     = `"v1"`
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		switch len(requests) {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			// The connection drops in the middle of the body
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+			w.Write(archive[:600])
+		default:
+			w.Header().Set("ETag", etag)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(archive))
+		}
+	}))
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), zipFileName)
+	f := &fetcher{client: s.Client(), attempts: 3, backoff: time.Millisecond}
+	_, err = f.fetch(s.URL, path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(requests))
+	assert.Equal(t, "bytes=600-", requests[2].Header.Get("Range"))
+	assert.Equal(t, `"v1"`, requests[2].Header.Get("If-Range"))
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, b)
+
+	// The archive changed between attempts, so it is downloaded from scratch
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, os.Remove(path+".etag"))
+	requests = nil
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if len(requests) == 1 {
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+			w.Write(archive[:600])
+			return
+		}
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(archive))
+	})
+
+	_, err = f.fetch(s.URL, path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(requests))
+	assert.Equal(t, "bytes=600-", requests[1].Header.Get("Range"))
+
+	b, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, b)
+
+	// Attempts run out
+	requests = nil
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	_, err = f.fetch(s.URL, path)
+	assert.Equal(t, "error 503 503 Service Unavailable", err.Error())
+	assert.Equal(t, 3, len(requests))
+
+	// Client errors are not retried
+	requests = nil
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.WriteHeader(http.StatusForbidden)
+	})
+	_, err = f.fetch(s.URL, path)
+	assert.Equal(t, "error 403 403 Forbidden", err.Error())
+	assert.Equal(t, 1, len(requests))
+
+	// Waits for the next attempt are given up when done is closed
+	requests = nil
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	done := make(chan struct{})
+	close(done)
+	f.backoff, f.done = time.Hour, done
+	_, err = f.fetch(s.URL, path)
+	assert.Equal(t, errCanceled, err)
+	assert.Equal(t, 1, len(requests))
+
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_verifyArchive(t *testing.T) {
+	dir := t.TempDir()
+	for name, ok := range map[string]bool{zipFileName: true, binZipFileName: true, mmdbTarFileName: true, "DB.CSV": false} {
+		err := verifyArchive("../../test/data/" + name)
+		assert.Equal(t, ok, err == nil, name)
+	}
+
+	b, err := os.ReadFile("../../test/data/" + mmdbTarFileName)
+	assert.NoError(t, err)
+	path := filepath.Join(dir, mmdbTarFileName)
+	assert.NoError(t, os.WriteFile(path, b[:len(b)-10], 0644))
+	assert.Equal(t, "corrupted gzip: unexpected EOF", verifyArchive(path).Error())
+}
+
+type clientFunc func(req *http.Request) (*http.Response, error)
+
+func (f clientFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// corrupt returns a copy of the zip archive with a changed CRC-32 of its first file in the central directory.
+func corrupt(archive []byte) []byte {
+	b := append([]byte{}, archive...)
+	b[bytes.Index(b, []byte("PK\x01\x02"))+16] ^= 0xff
+	return b
+}
+
+func sum(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
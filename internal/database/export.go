@@ -0,0 +1,102 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/ivanglie/iploc/internal/mmdb"
+)
+
+// ExportMMDB writes the loaded database to w in MaxMind DB format.
+// Locations are stored as GeoIP2 City records; ranges without a country are left out.
+func (db *DB) ExportMMDB(w io.Writer) error {
+	src := db.source()
+	if src == nil {
+		return ErrNotLoaded
+	}
+
+	mw := mmdb.NewWriter(db.Provider.Name())
+	mw.Languages = []string{"en"}
+	mw.Description = map[string]string{"en": db.Provider.Name() + " converted by iploc"}
+	mw.BuildEpoch = uint64(time.Now().Unix())
+
+	err := src.ranges(func(first, last Uint128, p map[Properties]string) error {
+		rec := geoIP2Record(p)
+		if rec == nil {
+			return nil
+		}
+
+		// MaxMind DB ranges can't cross the bounds of ::ffff:0:0/96, which maps IPv4.
+		return splitMapped(first, last, func(first, last Uint128, _ bool) error {
+			if err := mw.InsertRange(first.Addr(), last.Addr(), rec); err != nil {
+				return fmt.Errorf("exporting range %v-%v: %v", first, last, err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = mw.WriteTo(w)
+	return err
+}
+
+// geoIP2Record maps properties to a GeoIP2 City record, the inverse of mmdbProperties.
+// It returns nil for properties without a country.
+func geoIP2Record(p map[Properties]string) map[string]interface{} {
+	if !known(p[Code]) {
+		return nil
+	}
+
+	names := func(name string) map[string]interface{} {
+		return map[string]interface{}{"names": map[string]interface{}{"en": name}}
+	}
+
+	country := names(p[Country])
+	country["iso_code"] = p[Code]
+	rec := map[string]interface{}{"country": country}
+
+	if known(p[Region]) {
+		rec["subdivisions"] = []interface{}{names(p[Region])}
+	}
+
+	if known(p[City]) {
+		rec["city"] = names(p[City])
+	}
+
+	if known(p[ZipCode]) {
+		rec["postal"] = map[string]interface{}{"code": p[ZipCode]}
+	}
+
+	location := map[string]interface{}{}
+	lat, latErr := strconv.ParseFloat(p[Latitude], 64)
+	lon, lonErr := strconv.ParseFloat(p[Longitude], 64)
+	if latErr == nil && lonErr == nil {
+		location["latitude"], location["longitude"] = lat, lon
+	}
+
+	// GeoIP2 time zones are IANA names, IP2Location UTC offsets are left out.
+	if known(p[TimeZone]) && !isUTCOffset(p[TimeZone]) {
+		location["time_zone"] = p[TimeZone]
+	}
+
+	if len(location) != 0 {
+		rec["location"] = location
+	}
+
+	return rec
+}
+
+// isUTCOffset reports whether s is a UTC offset like "-07:00" rather than a time zone name.
+func isUTCOffset(s string) bool {
+	_, err := time.Parse("-07:00", s)
+	return err == nil
+}
+
+// known reports whether the property value s is neither empty nor the "-" placeholder.
+func known(s string) bool {
+	return len(s) != 0 && s != "-"
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ivanglie/iploc/internal/mmdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ExportMMDB(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	db := &DB{Provider: &ip2LocationCSV{code: ip2LocationCode}}
+	db.data.Store(&dataset{src: idx})
+	assert.NoError(t, db.ExportMMDB(&buf))
+
+	r, err := mmdb.FromBytes(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "IP2Location-DB11LITEIPV6", r.Metadata.DatabaseType)
+	assert.Equal(t, uint(6), r.Metadata.IPVersion)
+
+	// Every range is found with the same properties
+	m := &mmdbDB{reader: r}
+	for i, loc := range idx.locs {
+		for _, num := range []Uint128{idx.starts[i], idx.end(i)} {
+			l, err := m.search(num)
+			if loc == noLoc || idx.tuples[loc][Code] == "-" {
+				assert.Equal(t, Loc{}, l)
+				assert.Equal(t, num.String()+" not found", err.Error())
+				continue
+			}
+
+			// UTC offsets are not GeoIP2 time zones
+			expected := make(map[Properties]string, len(idx.tuples[loc]))
+			for k, v := range idx.tuples[loc] {
+				expected[k] = v
+			}
+			expected[TimeZone] = "-"
+
+			assert.NoError(t, err)
+			assert.Equal(t, expected, l.Properties)
+			assert.True(t, idx.starts[i].cmp(l.First) <= 0)
+			assert.True(t, idx.end(i).cmp(l.Last) >= 0)
+		}
+	}
+
+	// IPv4 is aliased by 6to4 addresses
+	n, _ := convertIP("2002:808:808::")
+	loc, err := m.search(n)
+	assert.NoError(t, err)
+	assert.Equal(t, "Mountain View", loc.Properties[City])
+
+	// Ranges crossing the bounds of ::ffff:0:0/96 are split
+	idx, err = readIndex(schemas[11], strings.NewReader(
+		`"281470681743104","281470681743871","US","United States of America","-","-","0","0","-","-"`))
+	assert.NoError(t, err)
+	db.data.Store(&dataset{src: idx})
+	buf.Reset()
+	assert.NoError(t, db.ExportMMDB(&buf))
+
+	r, err = mmdb.FromBytes(buf.Bytes())
+	assert.NoError(t, err)
+	m = &mmdbDB{reader: r}
+	for _, ip := range []string{"::fffe:ffff:ff00", "::fffe:ffff:ffff", "0.0.0.0", "0.0.1.255"} {
+		n, _ := convertIP(ip)
+		loc, err := m.search(n)
+		assert.NoError(t, err, ip)
+		assert.Equal(t, "US", loc.Properties[Code], ip)
+	}
+
+	// Errors
+	assert.Equal(t, "index is empty or not loaded", NewDB().ExportMMDB(&buf).Error())
+}
+
+func Test_geoIP2Record(t *testing.T) {
+	assert.Nil(t, geoIP2Record(map[Properties]string{Code: "-"}))
+
+	p := map[Properties]string{
+		Code:      "GB",
+		Country:   "United Kingdom of Great Britain and Northern Ireland",
+		Region:    "England",
+		City:      "-",
+		Latitude:  "51.564000",
+		Longitude: "-0.058080",
+		ZipCode:   "-",
+		TimeZone:  "Europe/London",
+	}
+	assert.Equal(t, map[string]interface{}{
+		"country": map[string]interface{}{
+			"iso_code": "GB",
+			"names":    map[string]interface{}{"en": "United Kingdom of Great Britain and Northern Ireland"},
+		},
+		"subdivisions": []interface{}{map[string]interface{}{"names": map[string]interface{}{"en": "England"}}},
+		"location":     map[string]interface{}{"latitude": 51.564, "longitude": -0.05808, "time_zone": "Europe/London"},
+	}, geoIP2Record(p))
+	assert.Equal(t, p, mmdbProperties(geoIP2Record(p)))
+
+	// UTC offsets are left out
+	p[TimeZone] = "+01:00"
+	assert.Equal(t, map[string]interface{}{"latitude": 51.564, "longitude": -0.05808}, geoIP2Record(p)["location"])
+}
@@ -0,0 +1,379 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"net/netip"
+	"sort"
+)
+
+// noLoc marks a gap between two ranges of the index.
+const noLoc = ^uint32(0)
+
+// Uint128 is a fixed-width 128-bit IP number, the IPv6 address or the IPv4-mapped IPv6 address as an integer.
+type Uint128 struct {
+	Hi, Lo uint64
+}
+
+// parseUint128 parses a decimal IP number.
+func parseUint128(s string) (u Uint128, err error) {
+	if len(s) == 0 {
+		err = errors.New("empty number")
+		return
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			err = fmt.Errorf("invalid number %q", s)
+			return
+		}
+
+		// u = u*10 + c
+		hi, lo := bits.Mul64(u.Lo, 10)
+		top, hi2 := bits.Mul64(u.Hi, 10)
+		hi, carry := bits.Add64(hi, hi2, 0)
+		if top != 0 || carry != 0 {
+			err = fmt.Errorf("number %s overflows 128 bits", s)
+			return
+		}
+
+		lo, carry = bits.Add64(lo, uint64(c-'0'), 0)
+		hi, carry = bits.Add64(hi, 0, carry)
+		if carry != 0 {
+			err = fmt.Errorf("number %s overflows 128 bits", s)
+			return
+		}
+
+		u = Uint128{Hi: hi, Lo: lo}
+	}
+
+	return
+}
+
+// cmp compares u and v and returns -1, 0 or +1.
+func (u Uint128) cmp(v Uint128) int {
+	switch {
+	case u.Hi < v.Hi, u.Hi == v.Hi && u.Lo < v.Lo:
+		return -1
+	case u.Hi == v.Hi && u.Lo == v.Lo:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// add returns u+n, wrapping around on overflow.
+func (u Uint128) add(n uint64) Uint128 {
+	lo, carry := bits.Add64(u.Lo, n, 0)
+	return Uint128{Hi: u.Hi + carry, Lo: lo}
+}
+
+// sub returns u-n, wrapping around on underflow.
+func (u Uint128) sub(n uint64) Uint128 {
+	lo, borrow := bits.Sub64(u.Lo, n, 0)
+	return Uint128{Hi: u.Hi - borrow, Lo: lo}
+}
+
+// plus returns u+v, wrapping around on overflow.
+func (u Uint128) plus(v Uint128) Uint128 {
+	lo, carry := bits.Add64(u.Lo, v.Lo, 0)
+	return Uint128{Hi: u.Hi + v.Hi + carry, Lo: lo}
+}
+
+// minus returns u-v, wrapping around on underflow.
+func (u Uint128) minus(v Uint128) Uint128 {
+	lo, borrow := bits.Sub64(u.Lo, v.Lo, 0)
+	return Uint128{Hi: u.Hi - v.Hi - borrow, Lo: lo}
+}
+
+// float returns u as float64, rounded.
+func (u Uint128) float() float64 {
+	return math.Ldexp(float64(u.Hi), 64) + float64(u.Lo)
+}
+
+// or returns u|v.
+func (u Uint128) or(v Uint128) Uint128 {
+	return Uint128{Hi: u.Hi | v.Hi, Lo: u.Lo | v.Lo}
+}
+
+// hostMask returns the mask of host bits of a prefix with length bits.
+func hostMask(bits int) Uint128 {
+	switch {
+	case bits <= 0:
+		return Uint128{Hi: ^uint64(0), Lo: ^uint64(0)}
+	case bits < 64:
+		return Uint128{Hi: ^uint64(0) >> bits, Lo: ^uint64(0)}
+	case bits < 128:
+		return Uint128{Lo: ^uint64(0) >> (bits - 64)}
+	default:
+		return Uint128{}
+	}
+}
+
+// splitMapped calls fn for the parts of the range from first to last before, within and after ::ffff:0:0/96,
+// skipping empty parts. v4 reports whether the part holds IPv4 addresses.
+func splitMapped(first, last Uint128, fn func(first, last Uint128, v4 bool) error) error {
+	v4First, v4Last := Uint128{Lo: 0xffff << 32}, Uint128{Lo: 0xffff<<32 | math.MaxUint32}
+	if first.cmp(v4First) < 0 {
+		end := last
+		if end.cmp(v4First) >= 0 {
+			end = v4First.sub(1)
+		}
+		if err := fn(first, end, false); err != nil {
+			return err
+		}
+	}
+
+	if first.cmp(v4Last) <= 0 && last.cmp(v4First) >= 0 {
+		from, end := first, last
+		if from.cmp(v4First) < 0 {
+			from = v4First
+		}
+		if end.cmp(v4Last) > 0 {
+			end = v4Last
+		}
+		if err := fn(from, end, true); err != nil {
+			return err
+		}
+	}
+
+	if last.cmp(v4Last) > 0 {
+		from := first
+		if from.cmp(v4Last) <= 0 {
+			from = v4Last.add(1)
+		}
+		return fn(from, last, false)
+	}
+	return nil
+}
+
+// uint128FromAddr returns the IP number of a. IPv4 addresses are mapped to ::ffff:0:0/96.
+func uint128FromAddr(a netip.Addr) Uint128 {
+	b := a.As16()
+	return Uint128{Hi: binary.BigEndian.Uint64(b[:8]), Lo: binary.BigEndian.Uint64(b[8:])}
+}
+
+// Addr returns u as IPv6 address.
+func (u Uint128) Addr() netip.Addr {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], u.Hi)
+	binary.BigEndian.PutUint64(b[8:], u.Lo)
+	return netip.AddrFrom16(b)
+}
+
+// big returns u as *big.Int.
+func (u Uint128) big() *big.Int {
+	n := new(big.Int).SetUint64(u.Hi)
+	n.Lsh(n, 64)
+	return n.Or(n, new(big.Int).SetUint64(u.Lo))
+}
+
+// String returns the decimal representation of u.
+func (u Uint128) String() string {
+	return u.big().String()
+}
+
+// MarshalText encodes u in decimal, JSON numbers of 128 bits are not portable.
+func (u Uint128) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// index is a sorted in-memory table of IP ranges.
+// Range i spans from starts[i] to starts[i+1]-1 (or to last for the final range)
+// and is located at tuples[locs[i]]. Gaps between ranges are stored as ranges with noLoc.
+type index struct {
+	starts []Uint128
+	locs   []uint32
+	last   Uint128
+
+	tuples []map[Properties]string // Deduplicated location tuples.
+}
+
+// readIndex builds the index of the CSV records streamed from r.
+func readIndex(columns []Properties, r io.Reader) (*index, error) {
+	b := newIndexBuilder(columns)
+	if err := b.readCSV(bufio.NewReaderSize(r, 1<<20)); err != nil {
+		return nil, err
+	}
+
+	return b.build()
+}
+
+// lookup returns the position of the range containing num.
+func (idx *index) lookup(num Uint128) (int, bool) {
+	n := len(idx.starts)
+	if n == 0 || num.cmp(idx.last) > 0 {
+		return 0, false
+	}
+
+	i := sort.Search(n, func(i int) bool { return idx.starts[i].cmp(num) > 0 }) - 1
+	if i < 0 || idx.locs[i] == noLoc {
+		return 0, false
+	}
+
+	return i, true
+}
+
+// end returns the last IP number of range i.
+func (idx *index) end(i int) Uint128 {
+	if i == len(idx.starts)-1 {
+		return idx.last
+	}
+
+	return idx.starts[i+1].sub(1)
+}
+
+// search location by num.
+func (idx *index) search(num Uint128) (Loc, error) {
+	i, ok := idx.lookup(num)
+	if !ok {
+		return Loc{}, fmt.Errorf("%v not found", num)
+	}
+
+	return Loc{First: idx.starts[i], Last: idx.end(i), Properties: idx.tuples[idx.locs[i]]}, nil
+}
+
+// overlaps calls fn for every range with a location overlapping the range from first to last in ascending order
+// until fn returns an error.
+func (idx *index) overlaps(first, last Uint128, fn func(first, last Uint128, p map[Properties]string) error) error {
+	i := sort.Search(len(idx.starts), func(i int) bool { return idx.starts[i].cmp(first) > 0 }) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	for ; i < len(idx.starts) && idx.starts[i].cmp(last) <= 0; i++ {
+		if idx.locs[i] == noLoc || idx.end(i).cmp(first) < 0 {
+			continue
+		}
+
+		if err := fn(idx.starts[i], idx.end(i), idx.tuples[idx.locs[i]]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ranges calls fn for every range with a location in ascending order until fn returns an error.
+func (idx *index) ranges(fn func(first, last Uint128, p map[Properties]string) error) error {
+	for i, loc := range idx.locs {
+		if loc == noLoc {
+			continue
+		}
+
+		if err := fn(idx.starts[i], idx.end(i), idx.tuples[loc]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// String returns a string representation of the index struct.
+func (idx *index) String() string {
+	return fmt.Sprintf("index{ranges: %d, locations: %d}", len(idx.starts), len(idx.tuples))
+}
+
+// indexBuilder accumulates sorted CSV records into an index.
+type indexBuilder struct {
+	idx     *index
+	columns []Properties
+	seen    map[string]uint32 // Locations by their joined values.
+	key     []byte
+	next    Uint128
+}
+
+func newIndexBuilder(columns []Properties) *indexBuilder {
+	return &indexBuilder{idx: &index{}, columns: columns, seen: make(map[string]uint32)}
+}
+
+// readCSV adds records of r to the index.
+func (b *indexBuilder) readCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(b.columns) + 2
+	reader.ReuseRecord = true
+
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = b.add(rec); err != nil {
+			line, _ := reader.FieldPos(0)
+			return fmt.Errorf("record on line %d: %v", line, err)
+		}
+	}
+}
+
+// add a record to the index.
+func (b *indexBuilder) add(rec []string) error {
+	first, err := parseUint128(rec[0])
+	if err != nil {
+		return err
+	}
+
+	last, err := parseUint128(rec[1])
+	if err != nil {
+		return err
+	}
+
+	if first.cmp(last) > 0 {
+		return fmt.Errorf("range %v-%v is reversed", first, last)
+	}
+
+	idx := b.idx
+	if n := len(idx.starts); n > 0 {
+		switch first.cmp(b.next) {
+		case -1:
+			return fmt.Errorf("range %v-%v is out of order", first, last)
+		case 1:
+			idx.starts = append(idx.starts, b.next)
+			idx.locs = append(idx.locs, noLoc)
+		}
+	}
+
+	b.key = b.key[:0]
+	for _, v := range rec[2:] {
+		b.key = append(append(b.key, v...), 0)
+	}
+
+	loc, ok := b.seen[string(b.key)]
+	if !ok {
+		loc = uint32(len(idx.tuples))
+		b.seen[string(b.key)] = loc
+
+		tuple := make(map[Properties]string, len(b.columns))
+		for i, c := range b.columns {
+			tuple[c] = rec[2+i]
+		}
+		idx.tuples = append(idx.tuples, tuple)
+	}
+
+	idx.starts = append(idx.starts, first)
+	idx.locs = append(idx.locs, loc)
+	idx.last = last
+	b.next = last.add(1)
+
+	return nil
+}
+
+// build returns the index.
+func (b *indexBuilder) build() (*index, error) {
+	if len(b.idx.starts) == 0 {
+		return nil, errors.New("no records found")
+	}
+
+	return b.idx, nil
+}
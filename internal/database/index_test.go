@@ -0,0 +1,188 @@
+package database
+
+import (
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// loadIndex builds the index of the CSV file at path.
+func loadIndex(columns []Properties, path string) (*index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readIndex(columns, f)
+}
+
+func Test_parseUint128(t *testing.T) {
+	for _, s := range []string{"0", "281470816487424", "18446744073709551616", "42541956123769884654463883030277652480",
+		"340282366920938463463374607431768211455"} {
+		n, err := parseUint128(s)
+		assert.NoError(t, err)
+
+		expected, _ := new(big.Int).SetString(s, 10)
+		assert.Equal(t, expected, n.big())
+		assert.Equal(t, s, n.String())
+	}
+
+	// Errors
+	_, err := parseUint128("")
+	assert.Equal(t, "empty number", err.Error())
+
+	_, err = parseUint128("12a")
+	assert.Equal(t, `invalid number "12a"`, err.Error())
+
+	_, err = parseUint128("340282366920938463463374607431768211456")
+	assert.Equal(t, "number 340282366920938463463374607431768211456 overflows 128 bits", err.Error())
+}
+
+func Test_uint128_cmp(t *testing.T) {
+	assert.Equal(t, 0, Uint128{1, 2}.cmp(Uint128{1, 2}))
+	assert.Equal(t, -1, Uint128{1, 2}.cmp(Uint128{1, 3}))
+	assert.Equal(t, -1, Uint128{0, 5}.cmp(Uint128{1, 0}))
+	assert.Equal(t, 1, Uint128{1, 0}.cmp(Uint128{0, ^uint64(0)}))
+	assert.Equal(t, Uint128{1, 0}, Uint128{0, ^uint64(0)}.add(1))
+	assert.Equal(t, Uint128{0, ^uint64(0)}, Uint128{1, 0}.sub(1))
+}
+
+func Test_loadIndex(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+	assert.NotNil(t, idx)
+
+	// Gap between IPv4 and IPv6 ranges
+	assert.Equal(t, 21, len(idx.starts))
+	assert.Equal(t, noLoc, idx.locs[10])
+
+	// Duplicated locations are stored once
+	assert.Equal(t, 16, len(idx.tuples))
+
+	// Errors
+	_, err = loadIndex(schemas[11], "../../test/data/DBincorrect.CSV")
+	assert.Equal(t, "record on line 5: wrong number of fields", err.Error())
+
+	_, err = loadIndex(schemas[11], "../../test/data/DBcsv")
+	assert.Equal(t, "open ../../test/data/DBcsv: no such file or directory", err.Error())
+
+	_, err = readIndex(schemas[11], strings.NewReader(""))
+	assert.Equal(t, "no records found", err.Error())
+
+	b := newIndexBuilder(schemas[11])
+	assert.NoError(t, b.readCSV(strings.NewReader(`"3","4","-","-","-","-","0","0","-","-"`)))
+	err = b.readCSV(strings.NewReader(`"1","2","-","-","-","-","0","0","-","-"`))
+	assert.Equal(t, "record on line 1: range 1-2 is out of order", err.Error())
+
+	b = newIndexBuilder(schemas[11])
+	err = b.readCSV(strings.NewReader(`"2","1","-","-","-","-","0","0","-","-"`))
+	assert.Equal(t, "record on line 1: range 2-1 is reversed", err.Error())
+
+	err = b.readCSV(strings.NewReader(`"x","1","-","-","-","-","0","0","-","-"`))
+	assert.Equal(t, `record on line 1: invalid number "x"`, err.Error())
+}
+
+func Test_indexBuilder_schemas(t *testing.T) {
+	b := newIndexBuilder(schemas[2])
+	assert.NoError(t, b.readCSV(strings.NewReader(`"16777216","16777471","AU","Australia","APNIC and Cloudflare DNS Resolver Project"
+"16777472","16778239","CN","China","Chinanet Fujian Province Network"
+"16778240","16779263","AU","Australia","APNIC and Cloudflare DNS Resolver Project"
+`)))
+
+	idx, err := b.build()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(idx.starts))
+	assert.Equal(t, 2, len(idx.tuples))
+
+	loc, err := idx.search(Uint128{Lo: 16778240})
+	assert.NoError(t, err)
+	assert.Equal(t, map[Properties]string{Code: "AU", Country: "Australia", ISP: "APNIC and Cloudflare DNS Resolver Project"},
+		loc.Properties)
+
+	// Values are separated in location keys
+	b = newIndexBuilder(schemas[2])
+	assert.NoError(t, b.readCSV(strings.NewReader(`"1","1","A","BC","D"
+"2","2","AB","C","D"
+`)))
+	assert.Equal(t, 2, len(b.idx.tuples))
+
+	// Records of other database types
+	err = newIndexBuilder(schemas[2]).readCSV(strings.NewReader(`"1","2","-","-","-","-","0","0","-","-"`))
+	assert.Equal(t, "record on line 1: wrong number of fields", err.Error())
+}
+
+func Test_index_search(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	// IPv4
+	n, _ := convertIP("8.8.8.8")
+	loc, err := idx.search(n)
+	assert.NoError(t, err)
+	assert.Equal(t, big2uint128("281470816487424"), loc.First)
+	assert.Equal(t, big2uint128("281470816487679"), loc.Last)
+	assert.Equal(t, "US", loc.Properties[Code])
+	assert.Equal(t, "United States of America", loc.Properties[Country])
+	assert.Equal(t, "California", loc.Properties[Region])
+	assert.Equal(t, "Mountain View", loc.Properties[City])
+	assert.Equal(t, "37.405992", loc.Properties[Latitude])
+	assert.Equal(t, "-122.078515", loc.Properties[Longitude])
+	assert.Equal(t, "94043", loc.Properties[ZipCode])
+	assert.Equal(t, "-07:00", loc.Properties[TimeZone])
+
+	// IPv6
+	n, _ = convertIP("2001:4860:4860:0:0:0:0:8888")
+	loc, err = idx.search(n)
+	assert.NoError(t, err)
+	assert.Equal(t, "GB", loc.Properties[Code])
+	assert.Equal(t, "United Kingdom of Great Britain and Northern Ireland", loc.Properties[Country])
+	assert.Equal(t, "England", loc.Properties[Region])
+	assert.Equal(t, "Upper Clapton", loc.Properties[City])
+	assert.Equal(t, "51.564000", loc.Properties[Latitude])
+	assert.Equal(t, "-0.058080", loc.Properties[Longitude])
+	assert.Equal(t, "E5", loc.Properties[ZipCode])
+	assert.Equal(t, "+01:00", loc.Properties[TimeZone])
+
+	// First and last numbers of the index
+	loc, err = idx.search(big2uint128("281470816482304"))
+	assert.NoError(t, err)
+	assert.Equal(t, "CA", loc.Properties[Code])
+
+	loc, err = idx.search(big2uint128("42541957369031178684983608137712926719"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Monroe", loc.Properties[City])
+
+	// Not found: before, in a gap and after
+	for _, s := range []string{"281470816482303", "281470816487936", "42541957369031178684983608137712926720"} {
+		loc, err = idx.search(big2uint128(s))
+		assert.Equal(t, Loc{}, loc)
+		assert.Equal(t, s+" not found", err.Error())
+	}
+}
+
+func Test_index_lookup_Allocs(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	n, _ := convertIP("8.8.8.8")
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, ok := idx.lookup(n); !ok {
+			t.Fatal("not found")
+		}
+	})
+	assert.Equal(t, float64(0), allocs)
+
+	allocs = testing.AllocsPerRun(100, func() {
+		_, _ = convertIP("2001:4860:4860::8888")
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func big2uint128(s string) Uint128 {
+	n, _ := parseUint128(s)
+	return n
+}
@@ -1,13 +1,9 @@
 package database
 
 import (
-	"encoding/csv"
 	"errors"
 	"fmt"
-	"math/big"
-	"net"
-	"os"
-	"strings"
+	"net/netip"
 )
 
 const (
@@ -19,139 +15,117 @@ const (
 	Longitude Properties = "Longitude" // City longitude. Default to capital city longitude if city is unknown.
 	ZipCode   Properties = "ZipCode"   // ZIP/Postal code.
 	TimeZone  Properties = "TimeZone"  // UTC time zone (with DST supported).
-)
 
-type Properties string
+	ISP                Properties = "ISP"                // Internet Service Provider or company's name.
+	Domain             Properties = "Domain"             // Internet domain name associated with IP address range.
+	NetSpeed           Properties = "NetSpeed"           // Internet connection type: DIAL, DSL, COMP or T1.
+	IDDCode            Properties = "IDDCode"            // The IDD prefix to call the city from another country.
+	AreaCode           Properties = "AreaCode"           // A varying length number assigned to geographic areas for calls between cities.
+	WeatherStationCode Properties = "WeatherStationCode" // The special code to identify the nearest weather observation station.
+	WeatherStationName Properties = "WeatherStationName" // The name of the nearest weather observation station.
+	MCC                Properties = "MCC"                // Mobile Country Codes (MCC) as defined in ITU E.212.
+	MNC                Properties = "MNC"                // Mobile Network Code (MNC) to uniquely identify a mobile network operator.
+	MobileBrand        Properties = "MobileBrand"        // Commercial brand associated with the mobile carrier.
+	Elevation          Properties = "Elevation"          // Average height of city above sea level in meters.
+	UsageType          Properties = "UsageType"          // Usage type classification of ISP or company.
+	AddressType        Properties = "AddressType"        // IP address types as defined in IANA: (A) Anycast, (U) Unicast, (M) Multicast or (B) Broadcast.
+	Category           Properties = "Category"           // The domain category based on IAB Tech Lab Content Taxonomy.
+	District           Properties = "District"           // District or county name.
+	ASN                Properties = "ASN"                // Autonomous system number (ASN).
+	AS                 Properties = "AS"                 // Autonomous system (AS) name.
+)
 
-type Loc struct {
-	FirstIP    *big.Int `json:"-"` // First IP address show netblock.
-	LastIP     *big.Int `json:"-"` // Last IP address show netblock.
-	Properties map[Properties]string
-}
+// allProperties are the properties in the order of Loc.String.
+var allProperties = []Properties{Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, ISP, Domain,
+	NetSpeed, IDDCode, AreaCode, WeatherStationCode, WeatherStationName, MCC, MNC, MobileBrand, Elevation, UsageType,
+	AddressType, Category, District, ASN, AS}
 
-func newLoc(firstIP, lastIP *big.Int, code, country, region, city, latitude, longitude, zipCode, timeZone string) *Loc {
-	loc := &Loc{FirstIP: firstIP, LastIP: lastIP}
+type Properties string
 
-	loc.Properties = make(map[Properties]string)
-	loc.Properties[Code] = code
-	loc.Properties[Country] = country
-	loc.Properties[Region] = region
-	loc.Properties[City] = city
-	loc.Properties[Latitude] = latitude
-	loc.Properties[Longitude] = longitude
-	loc.Properties[ZipCode] = zipCode
-	loc.Properties[TimeZone] = timeZone
+// IsProperty reports whether p is a property of some database type.
+func IsProperty(p Properties) bool {
+	for _, prop := range allProperties {
+		if prop == p {
+			return true
+		}
+	}
 
-	return loc
+	return false
 }
 
-// String representation of *IP.
-func (loc *Loc) String() string {
-	p := loc.Properties
-	return fmt.Sprintf(
-		`{"Code":"%s","Country":"%s","Region":"%s","City":"%s","Latitude":"%s","Longitude":"%s","ZipCode":"%s","TimeZone":"%s"}`,
-		p[Code], p[Country], p[Region], p[City], p[Latitude], p[Longitude], p[ZipCode], p[TimeZone])
+type Loc struct {
+	First      Uint128      `json:"-"` // First IP number of the netblock.
+	Last       Uint128      `json:"-"` // Last IP number of the netblock.
+	Network    netip.Prefix `json:"-"` // Largest network of the netblock containing the address looked up.
+	Properties map[Properties]string
 }
 
-// search location by address in file paths.
-func search(address string, paths []string) (loc *Loc, err error) {
-	num, err := convertIP(address)
-	if err != nil {
-		return
-	}
-
-	rec, err := searchChunk(num, paths)
-	if err != nil {
-		return
-	}
-
-	loc, err = searchByNum(num, rec)
-	if err != nil {
-		return
+// Range returns the first and the last address of the netblock. Addresses of IPv4 netblocks are IPv4 addresses.
+func (loc *Loc) Range() (first, last netip.Addr) {
+	first, last = loc.First.Addr(), loc.Last.Addr()
+	if first.Is4In6() && last.Is4In6() {
+		return first.Unmap(), last.Unmap()
 	}
 
 	return
 }
 
-// searchChunk where num is contained in file paths.
-func searchChunk(num *big.Int, paths []string) (r [][]string, err error) {
-	if len(paths) == 0 {
-		err = errors.New("chunks is empty or not found")
-		return
-	}
-
-	var f *os.File
-	mid := len(paths) / 2
-	f, err = os.Open(paths[mid])
-	if err != nil {
-		return
-	}
+// String returns the typed location of loc as JSON. Only properties of the database are written,
+// a location without properties has the properties of DB11.
+func (loc *Loc) String() string {
+	return loc.Location().String()
+}
 
-	reader := csv.NewReader(f)
-	reader.FieldsPerRecord = 10
-	rec, err := reader.ReadAll()
-	if err != nil {
+// convertIP address to num. The zone of an IPv6 address is ignored.
+func convertIP(address string) (num Uint128, err error) {
+	if len(address) == 0 {
+		err = errors.New("empty address")
 		return
 	}
 
-	first, _ := new(big.Int).SetString(rec[0][0], 0)
-	last, _ := new(big.Int).SetString(rec[len(rec)-1][1], 0)
-
-	switch {
-	case num.Cmp(last) > 0:
-		r, err = searchChunk(num, paths[mid:])
-	case num.Cmp(first) < 0:
-		r, err = searchChunk(num, paths[:mid])
-	case num.Cmp(first)+num.Cmp(last) == 0:
-		r = rec
+	ip, perr := netip.ParseAddr(address)
+	if perr != nil {
+		err = fmt.Errorf("address %s is incorrect IP", address)
 		return
 	}
 
+	// IPv4 addresses are mapped to ::ffff:0:0/96, as IP2Location stores them.
+	num = uint128FromAddr(ip)
 	return
 }
 
-// searchByNum search location by num into rec using binary search algorithm.
-func searchByNum(num *big.Int, rec [][]string) (loc *Loc, err error) {
-	mid := len(rec) / 2
-	first, _ := new(big.Int).SetString(rec[mid][0], 0)
-	last, _ := new(big.Int).SetString(rec[mid][1], 0)
-
-	switch {
-	case mid == 0:
-		err = fmt.Errorf("%v not found", num)
-		return
-	case num.Cmp(first)+num.Cmp(last) > 0:
-		loc, err = searchByNum(num, rec[mid:])
-	case num.Cmp(first)+num.Cmp(last) < 0:
-		loc, err = searchByNum(num, rec[:mid])
-	default:
-		s := rec[mid]
-		loc = newLoc(first, last, s[2], s[3], s[4], s[5], s[6], s[7], s[8], s[9])
-		return
+// PrefixRange returns the first and the last address of p.
+func PrefixRange(p netip.Prefix) (first, last netip.Addr) {
+	f, l := prefixRange(p)
+	first, last = f.Addr(), l.Addr()
+	if p.Addr().Is4() {
+		return first.Unmap(), last.Unmap()
 	}
 
 	return
 }
 
-// convertIP address to num.
-func convertIP(address string) (num *big.Int, err error) {
-	if len(address) == 0 {
-		err = errors.New("empty address")
-		return
+// network returns the largest network containing num within the range from first to last.
+// Networks of IPv4 ranges are IPv4 prefixes.
+func network(num, first, last Uint128) netip.Prefix {
+	bits := 0
+	for ; bits < 128; bits++ {
+		host := hostMask(bits)
+		start := Uint128{Hi: num.Hi &^ host.Hi, Lo: num.Lo &^ host.Lo}
+		if start.cmp(first) >= 0 && start.or(host).cmp(last) <= 0 {
+			break
+		}
 	}
 
-	if strings.Contains(address, ".") {
-		address = "::ffff:" + address
-	}
+	return prefix(num, bits)
+}
 
-	ip := net.ParseIP(address)
-	if ip == nil {
-		err = fmt.Errorf("address %s is incorrect IP", address)
-		return
+// prefix returns the network of num with length bits. Networks within ::ffff:0:0/96 are IPv4 prefixes.
+func prefix(num Uint128, bits int) netip.Prefix {
+	addr := num.Addr()
+	if addr.Is4In6() && bits >= 96 {
+		return netip.PrefixFrom(addr.Unmap(), bits-96).Masked()
 	}
 
-	// from http://golang.org/pkg/net/#pkg-constants
-	// IPv6len = 16
-	num = big.NewInt(0).SetBytes(ip.To16())
-	return
+	return netip.PrefixFrom(addr, bits).Masked()
 }
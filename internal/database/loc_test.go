@@ -1,181 +1,108 @@
 package database
 
 import (
-	"encoding/csv"
+	"encoding/json"
 	"math/big"
-	"os"
+	"net/netip"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-var data [][]string
+// newLoc returns the location with values of columns.
+func newLoc(first, last Uint128, columns []Properties, values ...string) *Loc {
+	loc := &Loc{First: first, Last: last}
 
-func setupTest(t *testing.T) {
-	t.Log("Setup test")
-
-	f, err := os.Open("../../test/data/DATA.CSV")
-	if err != nil {
-		t.Logf("Unable to read input file:\n%v", err)
-	}
-	defer f.Close()
-
-	r := csv.NewReader(f)
-	data, err = r.ReadAll()
-	if err != nil {
-		t.Logf("Unable to parse file as CSV:\n%v", err)
+	loc.Properties = make(map[Properties]string, len(columns))
+	for i, c := range columns {
+		loc.Properties[c] = values[i]
 	}
-}
-
-func teardownTest(t *testing.T) {
-	t.Log("Teardown test")
-}
-
-func Test_search(t *testing.T) {
-	loc, err := search("8.8.8.8", []string{"../../test/data/DB_0001.CSV", "../../test/data/DB_0002.CSV", "../../test/data/DB_0003.CSV"})
-	assert.Nil(t, err)
-	assert.NotNil(t, loc)
-	assert.NotNil(t, loc.Properties)
-	assert.Equal(t, "US", loc.Properties[Code])
-	assert.Equal(t, "United States of America", loc.Properties[Country])
-	assert.Equal(t, "California", loc.Properties[Region])
-	assert.Equal(t, "Mountain View", loc.Properties[City])
-	assert.Equal(t, "37.405992", loc.Properties[Latitude])
-	assert.Equal(t, "-122.078515", loc.Properties[Longitude])
-	assert.Equal(t, "94043", loc.Properties[ZipCode])
-	assert.Equal(t, "-07:00", loc.Properties[TimeZone])
-
-	// Errors
-	loc, err = search("8.8.8.", []string{"../../test/data/DB_0001.CSV", "../../test/data/DB_0002.CSV", "../../test/data/DB_0003.CSV"})
-	assert.Nil(t, loc)
-	assert.Equal(t, err.Error(), "address ::ffff:8.8.8. is incorrect IP")
-
-	loc, err = search("8.8.8.8", []string{})
-	assert.Nil(t, loc)
-	assert.Equal(t, err.Error(), "chunks is empty or not found")
-
-	loc, err = search("9.9.9.9", []string{"../../test/data/DB_0001.CSV", "../../test/data/DB_0002.CSV", "../../test/data/DB_0003.CSV"})
-	assert.Nil(t, loc)
-	assert.Equal(t, err.Error(), "281470833330441 not found")
-}
-
-func Test_searchChunk(t *testing.T) {
-	c, err := searchChunk(big.NewInt(281470816487424),
-		[]string{"../../test/data/DB_0001.CSV", "../../test/data/DB_0002.CSV", "../../test/data/DB_0003.CSV"})
-	assert.Nil(t, err)
-	assert.NotNil(t, c)
-
-	c, err = searchChunk(big.NewInt(281470816486912),
-		[]string{"../../test/data/DB_0001.CSV", "../../test/data/DB_0002.CSV", "../../test/data/DB_0003.CSV"})
-	assert.Nil(t, err)
-	assert.NotNil(t, c)
-
-	n, _ := new(big.Int).SetString("42541956123769884654463883030277652480", 0)
-	c, err = searchChunk(n, []string{"../../test/data/DB_0001.CSV", "../../test/data/DB_0002.CSV", "../../test/data/DB_0003.CSV"})
-	assert.Nil(t, err)
-	assert.NotNil(t, c)
-
-	// Errors
-	// Not found
-	c, err = searchChunk(big.NewInt(281470816482303),
-		[]string{"../../test/data/DB_0001.CSV", "../../test/data/DB_0002.CSV", "../../test/data/DB_0003.CSV"})
-	assert.Nil(t, c)
-	assert.Equal(t, err.Error(), "chunks is empty or not found")
-
-	// Chunks is empty
-	c, err = searchChunk(big.NewInt(281470816487424), []string{})
-	assert.Nil(t, c)
-	assert.Equal(t, err.Error(), "chunks is empty or not found")
-
-	// Incorrect format
-	c, err = searchChunk(big.NewInt(281470816487424), []string{"../../test/data/DBincorrect.CSV"})
-	assert.Nil(t, c)
-	assert.Equal(t, err.Error(), "record on line 5: wrong number of fields")
-
-	// No such file or directory
-	c, err = searchChunk(big.NewInt(281470816487424),
-		[]string{"../../test/data/DB_0001.CSV", "../../test/data/DB_0002csv", "../../test/data/DB_0003.CSV"})
-	assert.Nil(t, c)
-	assert.Equal(t, err.Error(), "open ../../test/data/DB_0002csv: no such file or directory")
-}
-
-func Test_searchByNum_IPv4(t *testing.T) {
-	setupTest(t)
-	defer teardownTest(t)
-
-	n, _ := convertIP("8.8.8.8")
-	loc, _ := searchByNum(n, data)
-	assert.NotNil(t, loc)
-	assert.NotNil(t, loc.Properties)
-	assert.Equal(t, "US", loc.Properties[Code])
-	assert.Equal(t, "United States of America", loc.Properties[Country])
-	assert.Equal(t, "California", loc.Properties[Region])
-	assert.Equal(t, "Mountain View", loc.Properties[City])
-	assert.Equal(t, "37.405992", loc.Properties[Latitude])
-	assert.Equal(t, "-122.078515", loc.Properties[Longitude])
-	assert.Equal(t, "94043", loc.Properties[ZipCode])
-	assert.Equal(t, "-07:00", loc.Properties[TimeZone])
-}
-
-func Test_searchByNum_IPv6(t *testing.T) {
-	setupTest(t)
-	defer teardownTest(t)
-
-	n, _ := convertIP("2001:4860:4860:0:0:0:0:8888")
-	loc, _ := searchByNum(n, data)
-	assert.NotNil(t, loc)
-	assert.NotNil(t, loc.Properties)
-	assert.Equal(t, "GB", loc.Properties[Code])
-	assert.Equal(t, "United Kingdom of Great Britain and Northern Ireland", loc.Properties[Country])
-	assert.Equal(t, "England", loc.Properties[Region])
-	assert.Equal(t, "Upper Clapton", loc.Properties[City])
-	assert.Equal(t, "51.564000", loc.Properties[Latitude])
-	assert.Equal(t, "-0.058080", loc.Properties[Longitude])
-	assert.Equal(t, "E5", loc.Properties[ZipCode])
-	assert.Equal(t, "+01:00", loc.Properties[TimeZone])
-}
-
-func Test_searchByNum_Errors(t *testing.T) {
-	setupTest(t)
-	defer teardownTest(t)
 
-	// Not found
-	n, _ := convertIP("9.9.9.9")
-	ip, err := searchByNum(n, data)
-	assert.Nil(t, ip)
-	assert.Equal(t, err.Error(), "281470833330441 not found")
+	return loc
 }
 
 func Test_convertIP_IPv4(t *testing.T) {
 	expectedNum, _ := new(big.Int).SetString("281473391529217", 0)
 	num, err := convertIP("161.132.13.1")
 	assert.Nil(t, err)
-	assert.Equal(t, expectedNum, num)
+	assert.Equal(t, expectedNum, num.big())
 }
 
 func Test_convertIP_IPv6(t *testing.T) {
 	expectedNum, _ := new(big.Int).SetString("42540766411282594074389245746715063092", 0)
 	num, err := convertIP("2001:0db8:0000:0042:0000:8a2e:0370:7334")
 	assert.Nil(t, err)
-	assert.Equal(t, expectedNum, num)
+	assert.Equal(t, expectedNum, num.big())
+
+	// Zone is ignored
+	zoned, err := convertIP("2001:0db8:0000:0042:0000:8a2e:0370:7334%eth0")
+	assert.Nil(t, err)
+	assert.Equal(t, num, zoned)
 }
 
 func Test_convertIP_Errors(t *testing.T) {
 	// Empty address
 	num, err := convertIP("")
-	assert.Nil(t, num)
+	assert.Equal(t, Uint128{}, num)
 	assert.Equal(t, err.Error(), "empty address")
 
 	// Incorrect IP
 	num, err = convertIP("8.8.8.")
-	assert.Nil(t, num)
+	assert.Equal(t, Uint128{}, num)
+	assert.Equal(t, err.Error(), "address 8.8.8. is incorrect IP")
+
+	num, err = convertIP("::ffff:8.8.8.")
+	assert.Equal(t, Uint128{}, num)
 	assert.Equal(t, err.Error(), "address ::ffff:8.8.8. is incorrect IP")
 }
 
+func TestIsProperty(t *testing.T) {
+	assert.True(t, IsProperty(City))
+	assert.True(t, IsProperty("AS"))
+	assert.False(t, IsProperty("city"))
+	assert.False(t, IsProperty(""))
+}
+
+func TestLoc_Range(t *testing.T) {
+	loc := &Loc{First: big2uint128("281470816487424"), Last: big2uint128("281470816487679")}
+	first, last := loc.Range()
+	assert.Equal(t, "8.8.8.0", first.String())
+	assert.Equal(t, "8.8.8.255", last.String())
+
+	first6 := uint128FromAddr(netip.MustParseAddr("2001:4860::"))
+	loc = &Loc{First: first6, Last: first6.or(hostMask(32))}
+	first, last = loc.Range()
+	assert.Equal(t, "2001:4860::", first.String())
+	assert.Equal(t, "2001:4860:ffff:ffff:ffff:ffff:ffff:ffff", last.String())
+}
+
+func TestPrefixRange(t *testing.T) {
+	first, last := PrefixRange(netip.MustParsePrefix("203.0.113.7/22"))
+	assert.Equal(t, "203.0.112.0", first.String())
+	assert.Equal(t, "203.0.115.255", last.String())
+
+	first, last = PrefixRange(netip.MustParsePrefix("2001:db8::/32"))
+	assert.Equal(t, "2001:db8::", first.String())
+	assert.Equal(t, "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", last.String())
+}
+
+func Test_network(t *testing.T) {
+	n := func(s string) Uint128 { return uint128FromAddr(netip.MustParseAddr(s)) }
+
+	assert.Equal(t, "8.8.8.0/24", network(n("8.8.8.8"), n("8.8.8.0"), n("8.8.8.255")).String())
+	assert.Equal(t, "8.8.8.8/29", network(n("8.8.8.8"), n("8.8.8.1"), n("8.8.8.255")).String())
+	assert.Equal(t, "8.8.8.128/25", network(n("8.8.8.255"), n("8.8.8.1"), n("8.8.8.255")).String())
+	assert.Equal(t, "8.8.8.1/32", network(n("8.8.8.1"), n("8.8.8.1"), n("8.8.8.255")).String())
+	assert.Equal(t, "2001:4860::/32", network(n("2001:4860::1"), n("2001:4860::"), n("2001:4860:ffff:ffff:ffff:ffff:ffff:ffff")).String())
+	assert.Equal(t, "::/0", network(n("::1"), Uint128{}, hostMask(0)).String())
+	assert.Equal(t, "::/1", network(n("::ffff:8.8.8.8"), Uint128{}, hostMask(1)).String())
+}
+
 func TestLocString(t *testing.T) {
 	loc := newLoc(
-		big.NewInt(281470816487424),
-		big.NewInt(281470816487679),
+		big2uint128("281470816487424"),
+		big2uint128("281470816487679"),
+		schemas[11],
 		"US",
 		"United States of America",
 		"California",
@@ -190,25 +117,76 @@ func TestLocString(t *testing.T) {
 		`"Country":"United States of America",`+
 		`"Region":"California",`+
 		`"City":"Mountain View",`+
-		`"Latitude":"37.405992",`+
-		`"Longitude":"-122.078515",`+
+		`"Latitude":37.405992,`+
+		`"Longitude":-122.078515,`+
 		`"ZipCode":"94043",`+
-		`"TimeZone":"-07:00"`+
+		`"TimeZone":"-07:00",`+
+		`"First":"8.8.8.0",`+
+		`"Last":"8.8.8.255"`+
+		`}`, loc.String())
+
+	// Escaping and unknown values
+	loc = newLoc(Uint128{Lo: 0xffff << 32}, Uint128{Lo: 0xffff<<32 | 0xff}, schemas[11], "us", "-",
+		`Quote " and backslash \`, "", "-", "1e400", "-", "-")
+	loc.Network = netip.MustParsePrefix("0.0.0.0/24")
+	assert.Equal(t, `{"Code":null,"Country":null,"Region":"Quote \" and backslash \\","City":null,"Latitude":null,`+
+		`"Longitude":null,"ZipCode":null,"TimeZone":null,"Network":"0.0.0.0/24","First":"0.0.0.0","Last":"0.0.0.255"}`,
+		loc.String())
+
+	loc = newLoc(Uint128{}, Uint128{}, schemas[5], "-", "-", "-", "-", "0.000000", "0.000000")
+	assert.Equal(t, `{"Code":null,"Country":null,"Region":null,"City":null,"Latitude":null,"Longitude":null}`,
+		loc.String())
+}
+
+func TestLocString_Schemas(t *testing.T) {
+	// Only properties of the database
+	loc := newLoc(Uint128{}, Uint128{Lo: 1}, schemas[1], "US", "United States of America")
+	assert.Equal(t, `{"Code":"US","Country":"United States of America","First":"::","Last":"::1"}`, loc.String())
+
+	loc = newLoc(Uint128{}, Uint128{Lo: 1}, schemas[2], "US", "United States of America", "Google LLC")
+	assert.Equal(t, `{"Code":"US","Country":"United States of America","ISP":"Google LLC","First":"::","Last":"::1"}`,
+		loc.String())
+
+	values := make([]string, len(schemas[26]))
+	for i, c := range schemas[26] {
+		values[i] = string(c)
+	}
+	values[0], values[4], values[5], values[18] = "US", "1.5", "-2", "30"
+	loc = newLoc(Uint128{}, Uint128{Lo: 1}, schemas[26], values...)
+	assert.Equal(t, `{`+
+		`"Code":"US","Country":"Country","Region":"Region","City":"City","Latitude":1.5,`+
+		`"Longitude":-2,"ZipCode":"ZipCode","TimeZone":"TimeZone","ISP":"ISP","Domain":"Domain",`+
+		`"NetSpeed":"NetSpeed","IDDCode":"IDDCode","AreaCode":"AreaCode","WeatherStationCode":"WeatherStationCode",`+
+		`"WeatherStationName":"WeatherStationName","MCC":"MCC","MNC":"MNC","MobileBrand":"MobileBrand",`+
+		`"Elevation":30,"UsageType":"UsageType","AddressType":"AddressType","Category":"Category",`+
+		`"District":"District","ASN":"ASN","AS":"AS","First":"::","Last":"::1"`+
 		`}`, loc.String())
 }
 
+func TestLocation_MarshalJSON(t *testing.T) {
+	// Locations not returned by a lookup
+	b, err := json.Marshal(Location{Code: "US", City: "X"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Code":"US","Country":null,"Region":null,"City":"X","Latitude":null,"Longitude":null,`+
+		`"ZipCode":null,"TimeZone":null}`, string(b))
+
+	asn := Location{Code: "US", ASN: "15169", First: netip.MustParseAddr("8.8.8.0"), Last: netip.MustParseAddr("8.8.8.255")}
+	assert.Equal(t, `{"Code":"US","Country":null,"Region":null,"City":null,"Latitude":null,"Longitude":null,`+
+		`"ZipCode":null,"TimeZone":null,"ASN":"15169","First":"8.8.8.0","Last":"8.8.8.255"}`, asn.String())
+}
+
 func TestLocString_Errors(t *testing.T) {
 	// Empty loc
 	loc := &Loc{}
 
 	assert.Equal(t, `{`+
-		`"Code":"",`+
-		`"Country":"",`+
-		`"Region":"",`+
-		`"City":"",`+
-		`"Latitude":"",`+
-		`"Longitude":"",`+
-		`"ZipCode":"",`+
-		`"TimeZone":""`+
+		`"Code":null,`+
+		`"Country":null,`+
+		`"Region":null,`+
+		`"City":null,`+
+		`"Latitude":null,`+
+		`"Longitude":null,`+
+		`"ZipCode":null,`+
+		`"TimeZone":null`+
 		`}`, loc.String())
 }
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/netip"
+	"strconv"
+)
+
+// Location is the typed location of a netblock. It is encoded in JSON with the names of the properties of the
+// database type, as Loc.String used to, followed by the range of the netblock.
+// Unknown values, empty or "-" in the database, are empty or nil and encoded as null.
+type Location struct {
+	Code               string   // ISO 3166-1 alpha-2 country code.
+	Country            string   // Country name.
+	Region             string   // Region or state name.
+	City               string   // City name.
+	Latitude           *float64 // City latitude.
+	Longitude          *float64 // City longitude.
+	ZipCode            string   // ZIP/Postal code.
+	TimeZone           string   // UTC offset or IANA time zone.
+	ISP                string   // Internet Service Provider or company's name.
+	Domain             string   // Internet domain name associated with IP address range.
+	NetSpeed           string   // Internet connection type.
+	IDDCode            string   // The IDD prefix to call the city from another country.
+	AreaCode           string   // Area code for calls between cities.
+	WeatherStationCode string   // Code of the nearest weather observation station.
+	WeatherStationName string   // Name of the nearest weather observation station.
+	MCC                string   // Mobile Country Code.
+	MNC                string   // Mobile Network Code.
+	MobileBrand        string   // Commercial brand of the mobile carrier.
+	Elevation          *float64 // Average height of city above sea level in meters.
+	UsageType          string   // Usage type of the ISP or company.
+	AddressType        string   // IANA address type: (A) Anycast, (U) Unicast, (M) Multicast or (B) Broadcast.
+	Category           string   // Domain category by the IAB Tech Lab Content Taxonomy.
+	District           string   // District or county name.
+	ASN                string   // Autonomous system number.
+	AS                 string   // Autonomous system name.
+
+	Network netip.Prefix // Largest network of the netblock containing the address looked up, if any.
+	First   netip.Addr   // First address of the netblock, if any.
+	Last    netip.Addr   // Last address of the netblock, if any.
+
+	properties []Properties // Properties of the database type, in the order of allProperties.
+}
+
+// stringFields are the string fields of Location by property.
+var stringFields = map[Properties]func(l *Location) *string{
+	Code:               func(l *Location) *string { return &l.Code },
+	Country:            func(l *Location) *string { return &l.Country },
+	Region:             func(l *Location) *string { return &l.Region },
+	City:               func(l *Location) *string { return &l.City },
+	ZipCode:            func(l *Location) *string { return &l.ZipCode },
+	TimeZone:           func(l *Location) *string { return &l.TimeZone },
+	ISP:                func(l *Location) *string { return &l.ISP },
+	Domain:             func(l *Location) *string { return &l.Domain },
+	NetSpeed:           func(l *Location) *string { return &l.NetSpeed },
+	IDDCode:            func(l *Location) *string { return &l.IDDCode },
+	AreaCode:           func(l *Location) *string { return &l.AreaCode },
+	WeatherStationCode: func(l *Location) *string { return &l.WeatherStationCode },
+	WeatherStationName: func(l *Location) *string { return &l.WeatherStationName },
+	MCC:                func(l *Location) *string { return &l.MCC },
+	MNC:                func(l *Location) *string { return &l.MNC },
+	MobileBrand:        func(l *Location) *string { return &l.MobileBrand },
+	UsageType:          func(l *Location) *string { return &l.UsageType },
+	AddressType:        func(l *Location) *string { return &l.AddressType },
+	Category:           func(l *Location) *string { return &l.Category },
+	District:           func(l *Location) *string { return &l.District },
+	ASN:                func(l *Location) *string { return &l.ASN },
+	AS:                 func(l *Location) *string { return &l.AS },
+}
+
+// floatFields are the numeric fields of Location by property.
+var floatFields = map[Properties]func(l *Location) **float64{
+	Latitude:  func(l *Location) **float64 { return &l.Latitude },
+	Longitude: func(l *Location) **float64 { return &l.Longitude },
+	Elevation: func(l *Location) **float64 { return &l.Elevation },
+}
+
+// Location returns the typed location of loc. A location without properties has the properties of DB11.
+// Invalid country codes and numbers are unknown, as are the coordinates of an unknown country.
+func (loc *Loc) Location() Location {
+	l := Location{Network: loc.Network, properties: schemas[11]}
+	if loc.Last != (Uint128{}) {
+		l.First, l.Last = loc.Range()
+	}
+
+	if len(loc.Properties) != 0 {
+		l.properties = make([]Properties, 0, len(loc.Properties))
+		for _, p := range allProperties {
+			if _, ok := loc.Properties[p]; ok {
+				l.properties = append(l.properties, p)
+			}
+		}
+	}
+
+	for p, v := range loc.Properties {
+		if len(v) == 0 || v == "-" {
+			continue
+		}
+
+		if f, ok := floatFields[p]; ok {
+			if x, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(x) && !math.IsInf(x, 0) {
+				*f(&l) = &x
+			}
+			continue
+		}
+
+		if p == Code && !isCountryCode(v) {
+			continue
+		}
+
+		if f, ok := stringFields[p]; ok {
+			*f(&l) = v
+		}
+	}
+
+	// IP2Location has coordinates 0, 0 in rows of unknown country.
+	if loc.Properties[Code] == "-" {
+		l.Latitude, l.Longitude = nil, nil
+	}
+
+	return l
+}
+
+// Property returns the value of the property name, like ISP or Latitude, as text.
+// It is empty if the value is unknown or the database type has no such property.
+func (l Location) Property(name string) string {
+	p := Properties(name)
+	if f, ok := floatFields[p]; ok {
+		if x := *f(&l); x != nil {
+			return strconv.FormatFloat(*x, 'f', -1, 64)
+		}
+		return ""
+	}
+
+	if f, ok := stringFields[p]; ok {
+		return *f(&l)
+	}
+
+	return ""
+}
+
+// isCountryCode reports whether code is an ISO 3166-1 alpha-2 code.
+func isCountryCode(code string) bool {
+	return len(code) == 2 && 'A' <= code[0] && code[0] <= 'Z' && 'A' <= code[1] && code[1] <= 'Z'
+}
+
+// MarshalJSON encodes the properties of the database type in their order, followed by the network and the range.
+// A Location not returned by a lookup encodes the properties of DB11 and any other property set.
+func (l Location) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+
+	for _, p := range l.fields() {
+		var v interface{}
+		if f, ok := floatFields[p]; ok {
+			if x := *f(&l); x != nil {
+				v = *x
+			}
+		} else if f, ok := stringFields[p]; ok {
+			if s := *f(&l); len(s) != 0 {
+				v = s
+			}
+		}
+
+		if err := writeField(&b, string(p), v); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.Network.IsValid() {
+		if err := writeField(&b, "Network", l.Network); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.First.IsValid() {
+		if err := writeField(&b, "First", l.First); err != nil {
+			return nil, err
+		}
+		if err := writeField(&b, "Last", l.Last); err != nil {
+			return nil, err
+		}
+	}
+
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// fields returns the properties encoded by MarshalJSON.
+func (l *Location) fields() []Properties {
+	if l.properties != nil {
+		return l.properties
+	}
+
+	db11 := make(map[Properties]bool, len(schemas[11]))
+	for _, p := range schemas[11] {
+		db11[p] = true
+	}
+
+	var ps []Properties
+	for _, p := range allProperties {
+		if db11[p] || len(l.Property(string(p))) != 0 {
+			ps = append(ps, p)
+		}
+	}
+
+	return ps
+}
+
+// String returns the location as JSON, or an empty object if it cannot be encoded.
+func (l Location) String() string {
+	b, err := l.MarshalJSON()
+	if err != nil {
+		return "{}"
+	}
+
+	return string(b)
+}
+
+// writeField writes the field of an object with the key and the JSON of v, preceded by a comma unless it is the first.
+func writeField(b *bytes.Buffer, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if b.Len() > 1 {
+		b.WriteByte(',')
+	}
+
+	k, _ := json.Marshal(key)
+	b.Write(k)
+	b.WriteByte(':')
+	b.Write(value)
+	return nil
+}
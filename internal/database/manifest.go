@@ -0,0 +1,115 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestFileName is the name of the manifest in the data directory.
+const manifestFileName = "manifest.json"
+
+// Manifest describes the archive prepared in the data directory, so that a restart can load it without downloading.
+type Manifest struct {
+	Source   string    `json:"source"`   // Name of the provider, like IP2Location-DB11LITEIPV6.
+	Snapshot string    `json:"snapshot"` // ID of the snapshot of the archive.
+	Archive  string    `json:"archive"`  // Path of the archive relative to the data directory.
+	Version  time.Time `json:"version"`  // Version date: the Last-Modified time of the vendor, or the download time.
+	SHA256   string    `json:"sha256"`   // Checksum of the archive in hex.
+	Size     int64     `json:"size"`     // Size of the archive.
+	Prepared time.Time `json:"prepared"` // Time when the archive was downloaded and validated.
+}
+
+// ReadManifest reads the manifest of the data directory dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("reading manifest: %v", err)
+	}
+
+	return m, nil
+}
+
+// newManifest describes the archive zip with the SHA-256 sum of the snapshot of source in the data directory dir.
+func newManifest(dir, source, snapshot, zip, sum string) (*Manifest, error) {
+	info, err := os.Stat(zip)
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(dir, zip)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manifest{
+		Source:   source,
+		Snapshot: snapshot,
+		Archive:  filepath.ToSlash(rel),
+		Version:  info.ModTime().UTC(),
+		SHA256:   sum,
+		Size:     info.Size(),
+		Prepared: time.Now().UTC(),
+	}, nil
+}
+
+// write writes the manifest to the directory dir.
+// It is written to a temporary file first, so a crash never leaves a partial one.
+func (m *Manifest) write(dir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, manifestFileName)
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// check verifies that the archive of the manifest in dir belongs to source and is intact, and returns its path.
+func (m *Manifest) check(dir, source string) (string, error) {
+	if m.Source != source {
+		return "", fmt.Errorf("manifest is of %s, not %s", m.Source, source)
+	}
+
+	zip := filepath.Join(dir, filepath.FromSlash(m.Archive))
+	sum, err := fileSHA256(zip)
+	if err != nil {
+		return "", err
+	}
+
+	if sum != m.SHA256 {
+		return "", fmt.Errorf("SHA-256 of %s is %s, expected %s", m.Archive, sum, m.SHA256)
+	}
+
+	return zip, nil
+}
+
+// fileSHA256 returns the SHA-256 of the file at path in hex.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ivanglie/iploc/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	zip := filepath.Join(dir, zipFileName)
+	assert.NoError(t, utils.CopyFile("../../test/data/"+zipFileName, zip))
+
+	version := time.Date(2023, 2, 17, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(zip, version, version))
+	checksum, err := fileSHA256(zip)
+	assert.NoError(t, err)
+	m, err := newManifest(dir, "IP2Location-DB11LITEIPV6", "1", zip, checksum)
+	assert.NoError(t, err)
+	assert.NoError(t, m.write(dir))
+
+	m, err = ReadManifest(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "IP2Location-DB11LITEIPV6", m.Source)
+	assert.Equal(t, "1", m.Snapshot)
+	assert.Equal(t, zipFileName, m.Archive)
+	assert.Equal(t, version, m.Version)
+	assert.Equal(t, int64(1254), m.Size)
+	assert.Equal(t, checksum, m.SHA256)
+	assert.WithinDuration(t, time.Now(), m.Prepared, time.Minute)
+
+	path, err := m.check(dir, "IP2Location-DB11LITEIPV6")
+	assert.NoError(t, err)
+	assert.Equal(t, zip, path)
+
+	// Errors
+	_, err = m.check(dir, "IP2Location-DB1LITE")
+	assert.Equal(t, "manifest is of IP2Location-DB11LITEIPV6, not IP2Location-DB1LITE", err.Error())
+
+	assert.NoError(t, os.Remove(zip))
+	_, err = m.check(dir, "IP2Location-DB11LITEIPV6")
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, manifestFileName), []byte("{"), 0644))
+	_, err = ReadManifest(dir)
+	assert.Equal(t, "reading manifest: unexpected end of JSON input", err.Error())
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+
+	"github.com/ivanglie/iploc/internal/mmdb"
+)
+
+// mmdbDB is a MaxMind database in MMDB format.
+type mmdbDB struct {
+	reader *mmdb.Reader
+}
+
+// readMMDB reads the MMDB database of size bytes from r.
+func readMMDB(r io.Reader, size int64) (*mmdbDB, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := mmdb.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mmdbDB{reader: reader}, nil
+}
+
+// search location by num.
+func (m *mmdbDB) search(num Uint128) (Loc, error) {
+	rec, network, err := m.reader.Lookup(num.Addr())
+	if err != nil {
+		return Loc{}, err
+	}
+
+	r, ok := rec.(map[string]interface{})
+	if !ok {
+		return Loc{}, fmt.Errorf("%v not found", num)
+	}
+
+	first, last := prefixRange(network)
+	return Loc{First: first, Last: last, Properties: mmdbProperties(r)}, nil
+}
+
+// ranges calls fn for every network with a GeoIP2 record until fn returns an error.
+func (m *mmdbDB) ranges(fn func(first, last Uint128, p map[Properties]string) error) error {
+	return m.reader.Networks(func(network netip.Prefix, rec interface{}) error {
+		r, ok := rec.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		first, last := prefixRange(network)
+		return fn(first, last, mmdbProperties(r))
+	})
+}
+
+// mmdbProperties maps a GeoIP2 City record to properties.
+// Missing values are "-" like in IP2Location databases.
+func mmdbProperties(r map[string]interface{}) map[Properties]string {
+	p := map[Properties]string{
+		Code:      mmdbString(r, "country", "iso_code"),
+		Country:   mmdbString(r, "country", "names", "en"),
+		Region:    "-",
+		City:      mmdbString(r, "city", "names", "en"),
+		Latitude:  mmdbCoordinate(r, "location", "latitude"),
+		Longitude: mmdbCoordinate(r, "location", "longitude"),
+		ZipCode:   mmdbString(r, "postal", "code"),
+		TimeZone:  mmdbString(r, "location", "time_zone"),
+	}
+
+	if subdivisions, ok := r["subdivisions"].([]interface{}); ok && len(subdivisions) > 0 {
+		if s, ok := subdivisions[0].(map[string]interface{}); ok {
+			p[Region] = mmdbString(s, "names", "en")
+		}
+	}
+
+	return p
+}
+
+// mmdbValue returns the value of r at path of map keys.
+func mmdbValue(r map[string]interface{}, path ...string) interface{} {
+	var v interface{} = r
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		v = m[key]
+	}
+
+	return v
+}
+
+// mmdbString returns the string of r at path or "-".
+func mmdbString(r map[string]interface{}, path ...string) string {
+	if s, ok := mmdbValue(r, path...).(string); ok && len(s) != 0 {
+		return s
+	}
+
+	return "-"
+}
+
+// mmdbCoordinate returns the coordinate of r at path with 6 decimals or "0.000000".
+func mmdbCoordinate(r map[string]interface{}, path ...string) string {
+	f, _ := mmdbValue(r, path...).(float64)
+	return strconv.FormatFloat(f, 'f', 6, 64)
+}
+
+// String returns a string representation of the mmdbDB struct.
+func (m *mmdbDB) String() string {
+	md := m.reader.Metadata
+	return fmt.Sprintf("MMDB{type: %s, ipVersion: %d, nodes: %d, built: %d}",
+		md.DatabaseType, md.IPVersion, md.NodeCount, md.BuildEpoch)
+}
+
+// prefixRange returns the first and last IP numbers of the network.
+// IPv4 networks are mapped to ::ffff:0:0/96.
+func prefixRange(network netip.Prefix) (first, last Uint128) {
+	bits := network.Bits()
+	if network.Addr().Is4() {
+		bits += 96
+	}
+
+	first = uint128FromAddr(network.Masked().Addr())
+	return first, first.or(hostMask(bits))
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ivanglie/iploc/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func testMMDB(t *testing.T) *mmdbDB {
+	tar := filepath.Join(t.TempDir(), mmdbTarFileName)
+	assert.NoError(t, utils.CopyFile("../../test/data/"+mmdbTarFileName, tar))
+
+	r, size, err := utils.OpenTarGz(tar, ".mmdb")
+	assert.NoError(t, err)
+	defer r.Close()
+
+	// Suffixes match in any case, as in zip archives
+	upper, _, err := utils.OpenTarGz(tar, ".MMDB")
+	assert.NoError(t, err)
+	upper.Close()
+
+	m, err := readMMDB(r, size)
+	assert.NoError(t, err)
+
+	return m
+}
+
+func Test_mmdbDB_search(t *testing.T) {
+	m := testMMDB(t)
+	assert.Equal(t, "MMDB{type: Test-City, ipVersion: 6, nodes: 149, built: 1676592000}", m.String())
+
+	// IPv4
+	n, _ := convertIP("8.8.8.8")
+	loc, err := m.search(n)
+	assert.NoError(t, err)
+	assert.Equal(t, big2uint128("281470816487424"), loc.First)
+	assert.Equal(t, big2uint128("281470816487679"), loc.Last)
+	assert.Equal(t, map[Properties]string{
+		Code:      "US",
+		Country:   "United States",
+		Region:    "California",
+		City:      "Mountain View",
+		Latitude:  "37.405600",
+		Longitude: "-122.077500",
+		ZipCode:   "94043",
+		TimeZone:  "America/Los_Angeles",
+	}, loc.Properties)
+
+	// IPv6 with missing values
+	n, _ = convertIP("2001:4860:4860::8888")
+	loc, err = m.search(n)
+	assert.NoError(t, err)
+	assert.Equal(t, uint128FromAddr(netip.MustParseAddr("2001:4860::")), loc.First)
+	assert.Equal(t, map[Properties]string{
+		Code:      "GB",
+		Country:   "United Kingdom",
+		Region:    "-",
+		City:      "-",
+		Latitude:  "51.496400",
+		Longitude: "-0.122400",
+		ZipCode:   "-",
+		TimeZone:  "Europe/London",
+	}, loc.Properties)
+
+	// Not found
+	n, _ = convertIP("9.9.9.9")
+	loc, err = m.search(n)
+	assert.Equal(t, Loc{}, loc)
+	assert.Equal(t, "281470833330441 not found", err.Error())
+
+	// Errors
+	_, err = readMMDB(strings.NewReader("not MMDB"), 8)
+	assert.Equal(t, "invalid MaxMind DB file: metadata not found", err.Error())
+}
+
+func Test_prefixRange(t *testing.T) {
+	first, last := prefixRange(netip.MustParsePrefix("8.8.8.0/24"))
+	assert.Equal(t, "::ffff:8.8.8.0", first.Addr().String())
+	assert.Equal(t, "::ffff:8.8.8.255", last.Addr().String())
+
+	first, last = prefixRange(netip.MustParsePrefix("2001:4860::/32"))
+	assert.Equal(t, "2001:4860::", first.Addr().String())
+	assert.Equal(t, "2001:4860:ffff:ffff:ffff:ffff:ffff:ffff", last.Addr().String())
+
+	first, last = prefixRange(netip.MustParsePrefix("::/0"))
+	assert.Equal(t, Uint128{}, first)
+	assert.Equal(t, hostMask(0), last)
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/ivanglie/iploc/internal/utils"
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+// Provider fetches and parses the database of a vendor in one file format.
+type Provider interface {
+	// Name of the database, like IP2Location-DB11LITEIPV6.
+	Name() string
+
+	// Fetch downloads the database archive using token (or license key) to dir and returns its path.
+	Fetch(f *fetcher, token, dir string) (path string, err error)
+
+	// Archive returns the file name of the archive written by Fetch.
+	Archive() string
+
+	// Sample returns the file name of the archive in test/data used for local development.
+	Sample() string
+
+	// Parse reads the archive at path, logging the progress to l.
+	Parse(path string, l log.Logger) (Source, error)
+}
+
+// Source is a parsed database.
+type Source interface {
+	// search location by num.
+	search(num Uint128) (Loc, error)
+
+	// ranges calls fn for every range with a location until fn returns an error.
+	ranges(fn func(first, last Uint128, p map[Properties]string) error) error
+
+	String() string
+}
+
+// NewProvider returns the provider of the vendor's database with code.
+// IP2Location codes start with the database type (DB1 to DB26), which defines the CSV columns.
+// Codes of IP2Location BIN distributions (like DB11LITEBINIPV6) are read in BIN format, others in CSV.
+// Empty code means DB11LITEIPV6 for IP2Location and GeoLite2-City for MaxMind.
+func NewProvider(vendor, code string) (Provider, error) {
+	switch vendor {
+	case IP2Location:
+		if len(code) == 0 {
+			code = ip2LocationCode
+		}
+
+		t, err := dbType(code)
+		if err != nil {
+			return nil, err
+		}
+
+		if isBIN(code) {
+			return &ip2LocationBIN{code: code}, nil
+		}
+		return &ip2LocationCSV{code: code, columns: schemas[t]}, nil
+	case MaxMind:
+		if len(code) == 0 {
+			code = maxMindEdition
+		}
+		return &maxMind{edition: code}, nil
+	default:
+		return nil, fmt.Errorf("unknown vendor %q", vendor)
+	}
+}
+
+// ip2LocationCSV is the provider of IP2Location databases in CSV format.
+type ip2LocationCSV struct {
+	code    string
+	columns []Properties // Columns of the database type of code.
+}
+
+func (p *ip2LocationCSV) Name() string {
+	return "IP2Location-" + p.code
+}
+
+func (p *ip2LocationCSV) Fetch(f *fetcher, token, dir string) (string, error) {
+	return f.fetch(ip2LocationURL(token, p.code), filepath.Join(dir, p.Archive()))
+}
+
+func (p *ip2LocationCSV) Archive() string {
+	return p.code + ".zip"
+}
+
+func (p *ip2LocationCSV) Sample() string {
+	return zipFileName
+}
+
+// Parse builds the index of the CSV file streamed from the archive, without extracting it.
+func (p *ip2LocationCSV) Parse(path string, l log.Logger) (Source, error) {
+	csv, size, err := utils.OpenZip(path, ".CSV")
+	if err != nil {
+		return nil, err
+	}
+	defer csv.Close()
+
+	l.Info(fmt.Sprintf("Index %d bytes of CSV...", size))
+	idx, err := readIndex(p.columns, csv)
+	if err != nil {
+		return nil, fmt.Errorf("indexing: %v", err)
+	}
+	l.Info(fmt.Sprintf("Index completed: %v", idx))
+
+	return idx, nil
+}
+
+// ip2LocationBIN is the provider of IP2Location databases in BIN format.
+type ip2LocationBIN struct {
+	code string
+}
+
+func (p *ip2LocationBIN) Name() string {
+	return "IP2Location-" + p.code
+}
+
+func (p *ip2LocationBIN) Fetch(f *fetcher, token, dir string) (string, error) {
+	return f.fetch(ip2LocationURL(token, p.code), filepath.Join(dir, p.Archive()))
+}
+
+func (p *ip2LocationBIN) Archive() string {
+	return p.code + ".zip"
+}
+
+func (p *ip2LocationBIN) Sample() string {
+	return binZipFileName
+}
+
+// Parse reads the BIN file from the archive into memory, without extracting it.
+func (p *ip2LocationBIN) Parse(path string, l log.Logger) (Source, error) {
+	bin, size, err := utils.OpenZip(path, ".BIN")
+	if err != nil {
+		return nil, err
+	}
+	defer bin.Close()
+
+	b, err := readBIN(bin, size)
+	if err != nil {
+		return nil, fmt.Errorf("reading BIN: %v", err)
+	}
+	l.Info(fmt.Sprintf("BIN loaded: %v", b))
+
+	return b, nil
+}
+
+// ip2LocationURL returns the download link of the IP2Location database with code.
+func ip2LocationURL(token, code string) string {
+	return fmt.Sprintf("%s?token=%s&file=%s", baseUrl, token, code)
+}
+
+// maxMind is the provider of MaxMind databases in MMDB format.
+type maxMind struct {
+	edition string
+}
+
+func (p *maxMind) Name() string {
+	return "MaxMind-" + p.edition
+}
+
+func (p *maxMind) Fetch(f *fetcher, token, dir string) (string, error) {
+	url := fmt.Sprintf("%s?edition_id=%s&license_key=%s&suffix=tar.gz", maxMindURL, p.edition, token)
+	return f.fetch(url, filepath.Join(dir, p.Archive()))
+}
+
+func (p *maxMind) Archive() string {
+	return p.edition + ".tar.gz"
+}
+
+func (p *maxMind) Sample() string {
+	return mmdbTarFileName
+}
+
+// Parse reads the MMDB file from the archive into memory, without extracting it.
+func (p *maxMind) Parse(path string, l log.Logger) (Source, error) {
+	mmdb, size, err := utils.OpenTarGz(path, ".mmdb")
+	if err != nil {
+		return nil, err
+	}
+	defer mmdb.Close()
+
+	m, err := readMMDB(mmdb, size)
+	if err != nil {
+		return nil, fmt.Errorf("reading MMDB: %v", err)
+	}
+	l.Info(fmt.Sprintf("MMDB loaded: %v", m))
+
+	return m, nil
+}
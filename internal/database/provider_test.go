@@ -0,0 +1,138 @@
+package database
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ivanglie/iploc/internal/utils"
+	"github.com/ivanglie/iploc/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type urlClient struct {
+	mockClient
+	url string
+}
+
+func (c *urlClient) Do(req *http.Request) (*http.Response, error) {
+	c.url = req.URL.String()
+	return c.mockClient.Do(req)
+}
+
+func TestNewProvider(t *testing.T) {
+	for _, tc := range []struct {
+		vendor, code string
+		provider     Provider
+		name, sample string
+	}{
+		{IP2Location, "", &ip2LocationCSV{code: "DB11LITEIPV6", columns: schemas[11]}, "IP2Location-DB11LITEIPV6", zipFileName},
+		{IP2Location, "DB5LITECSV", &ip2LocationCSV{code: "DB5LITECSV", columns: schemas[5]}, "IP2Location-DB5LITECSV", zipFileName},
+		{IP2Location, "DB11LITEBINIPV6", &ip2LocationBIN{code: "DB11LITEBINIPV6"}, "IP2Location-DB11LITEBINIPV6", binZipFileName},
+		{MaxMind, "", &maxMind{edition: "GeoLite2-City"}, "MaxMind-GeoLite2-City", mmdbTarFileName},
+		{MaxMind, "GeoIP2-City", &maxMind{edition: "GeoIP2-City"}, "MaxMind-GeoIP2-City", mmdbTarFileName},
+	} {
+		p, err := NewProvider(tc.vendor, tc.code)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.provider, p)
+		assert.Equal(t, tc.name, p.Name())
+		assert.Equal(t, tc.sample, p.Sample())
+	}
+
+	// Errors
+	_, err := NewProvider("unknown", "")
+	assert.Equal(t, `unknown vendor "unknown"`, err.Error())
+
+	_, err = NewProvider(IP2Location, "PX2LITEBIN")
+	assert.Equal(t, `unsupported IP2Location database code "PX2LITEBIN"`, err.Error())
+}
+
+func TestProvider_Fetch(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		provider Provider
+		url      string
+		name     string
+	}{
+		{&ip2LocationCSV{code: "DB11LITEIPV6"}, baseUrl + "?token=token&file=DB11LITEIPV6", "DB11LITEIPV6.zip"},
+		{&ip2LocationBIN{code: "DB11LITEBINIPV6"}, baseUrl + "?token=token&file=DB11LITEBINIPV6", "DB11LITEBINIPV6.zip"},
+		{&maxMind{edition: "GeoLite2-City"}, maxMindURL + "?edition_id=GeoLite2-City&license_key=token&suffix=tar.gz",
+			"GeoLite2-City.tar.gz"},
+	} {
+		c := &urlClient{}
+		path, err := tc.provider.Fetch(&fetcher{client: c}, "token", dir)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.url, c.url)
+		assert.Equal(t, filepath.Join(dir, tc.name), path)
+		assert.Equal(t, tc.name, tc.provider.Archive())
+
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1254), info.Size())
+	}
+
+	// Errors
+	_, err := (&maxMind{edition: "GeoLite2-City"}).Fetch(&fetcher{client: &badStatusClient{}}, "token", dir)
+	assert.Equal(t, "error 503 Service Unavailable", err.Error())
+}
+
+func TestProvider_Parse(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []Provider{&ip2LocationCSV{code: ip2LocationCode, columns: schemas[11]}, &ip2LocationBIN{code: "DB11LITEBINIPV6"},
+		&maxMind{edition: maxMindEdition}} {
+		path := filepath.Join(dir, p.Sample())
+		assert.NoError(t, utils.CopyFile("../../test/data/"+p.Sample(), path))
+
+		s, err := p.Parse(path, log.Default())
+		assert.NoError(t, err)
+
+		n, _ := convertIP("8.8.8.8")
+		loc, err := s.search(n)
+		assert.NoError(t, err)
+		assert.Equal(t, "Mountain View", loc.Properties[City])
+
+		// Nothing is extracted
+		entries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(entries))
+		assert.NoError(t, os.Remove(path))
+	}
+
+	// Errors
+	_, err := (&ip2LocationCSV{code: ip2LocationCode, columns: schemas[11]}).Parse(filepath.Join(dir, "not_found.zip"), log.Default())
+	assert.Error(t, err)
+}
+
+func TestSource_ranges(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	type rng struct {
+		first, last Uint128
+		code        string
+	}
+
+	collect := func(s Source) (r []rng) {
+		assert.NoError(t, s.ranges(func(first, last Uint128, p map[Properties]string) error {
+			r = append(r, rng{first, last, p[Code]})
+			return nil
+		}))
+		return
+	}
+
+	expected := collect(idx)
+	assert.Equal(t, 20, len(expected))
+
+	// BIN databases have the same ranges in the same order and rows for gaps
+	gap := rng{idx.starts[10], Uint128{Lo: 0xffff<<32 | math.MaxUint32}, "-"}
+	assert.Equal(t, append(append(append([]rng{}, expected[:10]...), gap), expected[10:]...), collect(testBIN(t, true)))
+
+	// MMDB databases have networks
+	assert.Equal(t, []rng{
+		{big2uint128("281470816487424"), big2uint128("281470816487679"), "US"},
+		{uint128FromAddr(netip.MustParseAddr("2001:4860::")), uint128FromAddr(netip.MustParseAddr("2001:4860:ffff:ffff:ffff:ffff:ffff:ffff")), "GB"},
+	}, collect(testMMDB(t)))
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// reverseProperties are the properties of reverse lookups.
+var reverseProperties = []Properties{Code, Region, City}
+
+// span is a range of IP numbers.
+type span struct {
+	first, last Uint128
+}
+
+// reverseIndex keeps the ranges of the locations of a source once and maps the values of reverseProperties,
+// in lower case, to the positions of their ranges by country code, as names of regions and cities repeat
+// across countries. Consecutive adjacent ranges of the same location are kept as one.
+type reverseIndex struct {
+	spans  []span
+	values map[Properties]map[string]map[string][]int32
+}
+
+// AddrRange is a range of addresses from First to Last.
+type AddrRange struct {
+	First netip.Addr `json:"first"`
+	Last  netip.Addr `json:"last"`
+}
+
+// NetworkList is a list of ranges and the minimal list of networks covering them.
+type NetworkList struct {
+	Ranges []AddrRange    `json:"ranges"`
+	CIDRs  []netip.Prefix `json:"cidrs"`
+}
+
+// Networks are the ranges of a location by IP version.
+type Networks struct {
+	IPv4 NetworkList `json:"ipv4"`
+	IPv6 NetworkList `json:"ipv6"`
+}
+
+// newReverseIndex builds the reverse index of the ranges of s.
+func newReverseIndex(s Source) (*reverseIndex, error) {
+	ri := &reverseIndex{values: make(map[Properties]map[string]map[string][]int32, len(reverseProperties))}
+	for _, p := range reverseProperties {
+		ri.values[p] = map[string]map[string][]int32{}
+	}
+
+	keys := map[string]string{} // Values to their keys, lowering every value once.
+	key := func(v string) string {
+		k, ok := keys[v]
+		if !ok {
+			k = strings.ToLower(v)
+			keys[v] = k
+		}
+		return k
+	}
+
+	var prev [3]string // Values of reverseProperties of the last span.
+	err := s.ranges(func(first, last Uint128, props map[Properties]string) error {
+		var loc [3]string
+		for i, p := range reverseProperties {
+			if v := props[p]; known(v) {
+				loc[i] = v
+			}
+		}
+
+		if loc == [3]string{} {
+			return nil
+		}
+
+		n := len(ri.spans)
+		if n > 0 && loc == prev && ri.spans[n-1].last.cmp(hostMask(0)) < 0 && ri.spans[n-1].last.add(1) == first {
+			ri.spans[n-1].last = last
+			return nil
+		}
+
+		ri.spans, prev = append(ri.spans, span{first, last}), loc
+		code := key(loc[0])
+		for i, p := range reverseProperties {
+			if len(loc[i]) == 0 {
+				continue
+			}
+
+			byCode := ri.values[p][key(loc[i])]
+			if byCode == nil {
+				byCode = map[string][]int32{}
+				ri.values[p][key(loc[i])] = byCode
+			}
+			byCode[code] = append(byCode[code], int32(n))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ri, nil
+}
+
+// lookup returns the ranges of the property p with value, in the country code if not empty,
+// in ascending order with the adjacent ones merged.
+func (ri *reverseIndex) lookup(p Properties, value, code string) []span {
+	var spans []span
+	for c, positions := range ri.values[p][strings.ToLower(value)] {
+		if len(code) != 0 && c != strings.ToLower(code) {
+			continue
+		}
+
+		for _, i := range positions {
+			spans = append(spans, ri.spans[i])
+		}
+	}
+
+	// Ranges of MMDB sources are not in ascending order.
+	return mergeSpans(spans)
+}
+
+// mergeSpans sorts spans and merges the adjacent and overlapping ones.
+func mergeSpans(spans []span) []span {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].first.cmp(spans[j].first) < 0 })
+
+	merged := spans[:0]
+	for _, s := range spans {
+		if n := len(merged); n > 0 && (merged[n-1].last.cmp(hostMask(0)) == 0 || merged[n-1].last.add(1).cmp(s.first) >= 0) {
+			if s.last.cmp(merged[n-1].last) > 0 {
+				merged[n-1].last = s.last
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	return merged
+}
+
+// Networks returns the ranges of the property p (Code, Region or City) with value, compared case-insensitively,
+// and the minimal lists of networks covering them, by IP version. Regions and cities are those of the country code,
+// if not empty, or of any country.
+func (db *DB) Networks(p Properties, value, code string) (*Networks, error) {
+	ds := db.data.Load()
+	if ds == nil {
+		return nil, ErrNotLoaded
+	}
+
+	if _, ok := ds.reverse.values[p]; !ok {
+		return nil, fmt.Errorf("property %s is not indexed", p)
+	}
+
+	n := &Networks{IPv4: NetworkList{Ranges: []AddrRange{}, CIDRs: []netip.Prefix{}},
+		IPv6: NetworkList{Ranges: []AddrRange{}, CIDRs: []netip.Prefix{}}}
+
+	for _, s := range ds.reverse.lookup(p, value, code) {
+		// Spans are split at the bounds of ::ffff:0:0/96, which are IPv4 addresses.
+		splitMapped(s.first, s.last, func(first, last Uint128, v4 bool) error {
+			if v4 {
+				n.IPv4.add(first, last)
+			} else {
+				n.IPv6.add(first, last)
+			}
+			return nil
+		})
+	}
+
+	return n, nil
+}
+
+// add adds the range from first to last and its networks to the list.
+func (l *NetworkList) add(first, last Uint128) {
+	loc := Loc{First: first, Last: last}
+	r := AddrRange{}
+	r.First, r.Last = loc.Range()
+	l.Ranges = append(l.Ranges, r)
+	l.CIDRs = append(l.CIDRs, prefixes(first, last)...)
+}
+
+// prefixes returns the minimal list of networks covering the range from first to last.
+// Networks of IPv4 ranges are IPv4 prefixes.
+func prefixes(first, last Uint128) []netip.Prefix {
+	var ps []netip.Prefix
+	for {
+		// The largest network starting at first and ending at last at most.
+		bits := 128
+		for bits > 0 {
+			host := hostMask(bits - 1)
+			if first.Hi&host.Hi != 0 || first.Lo&host.Lo != 0 || first.or(host).cmp(last) > 0 {
+				break
+			}
+			bits--
+		}
+
+		ps = append(ps, prefix(first, bits))
+
+		end := first.or(hostMask(bits))
+		if end.cmp(last) >= 0 {
+			return ps
+		}
+		first = end.add(1)
+	}
+}
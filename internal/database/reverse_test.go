@@ -0,0 +1,122 @@
+package database
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Networks(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	cidrs := func(ps []netip.Prefix) (s []string) {
+		for _, p := range ps {
+			s = append(s, p.String())
+		}
+		return
+	}
+
+	// The reverse indexes of all sources are the same
+	for _, src := range []Source{idx, testBIN(t, true)} {
+		ri, err := newReverseIndex(src)
+		assert.NoError(t, err)
+
+		db := &DB{}
+		db.data.Store(&dataset{src: src, reverse: ri})
+
+		// Adjacent ranges are merged
+		n, err := db.Networks(Code, "us", "")
+		assert.NoError(t, err)
+		assert.Equal(t, []AddrRange{{netip.MustParseAddr("8.7.245.0"), netip.MustParseAddr("8.8.9.255")}}, n.IPv4.Ranges)
+		assert.Equal(t, []string{"8.7.245.0/24", "8.7.246.0/23", "8.7.248.0/21", "8.8.0.0/21", "8.8.8.0/23"},
+			cidrs(n.IPv4.CIDRs))
+		assert.Equal(t, 5, len(n.IPv6.Ranges))
+		assert.Equal(t, "2001:4860:7:70e::/64", n.IPv6.CIDRs[0].String())
+
+		n, err = db.Networks(City, "Monroe", "")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"8.7.247.0/24", "8.7.248.0/21", "8.8.0.0/22"}, cidrs(n.IPv4.CIDRs))
+		assert.Equal(t, []AddrRange{{netip.MustParseAddr("2001:4870::"),
+			netip.MustParseAddr("2001:4870:7:ffff:ffff:ffff:ffff:ffff")}}, n.IPv6.Ranges)
+		assert.Equal(t, []string{"2001:4870::/45"}, cidrs(n.IPv6.CIDRs))
+
+		n, err = db.Networks(Region, "England", "")
+		assert.NoError(t, err)
+		assert.Empty(t, n.IPv4.Ranges)
+		assert.Equal(t, []string{"2001:4860:4860::/64"}, cidrs(n.IPv6.CIDRs))
+
+		// Not found
+		n, err = db.Networks(Code, "ZZ", "")
+		assert.NoError(t, err)
+		assert.Equal(t, &Networks{IPv4: NetworkList{Ranges: []AddrRange{}, CIDRs: []netip.Prefix{}},
+			IPv6: NetworkList{Ranges: []AddrRange{}, CIDRs: []netip.Prefix{}}}, n)
+
+		n, err = db.Networks(Code, "-", "")
+		assert.NoError(t, err)
+		assert.Empty(t, n.IPv6.Ranges)
+
+		// Errors
+		_, err = db.Networks(ISP, "Google LLC", "")
+		assert.Equal(t, "property ISP is not indexed", err.Error())
+	}
+
+	_, err = (&DB{}).Networks(Code, "US", "")
+	assert.Equal(t, ErrNotLoaded, err)
+
+	// Cities of the same name in different countries
+	idx, err = readIndex(schemas[11], strings.NewReader(
+		`"281470816487424","281470816487679","US","United States of America","Texas","Paris","0","0","-","-"
+"281470816487680","281470816487935","FR","France","Ile-de-France","Paris","0","0","-","-"
+"281470816487936","281470816488191","FR","France","Ile-de-France","Paris","0","0","75001","-"`))
+	assert.NoError(t, err)
+	ri, err := newReverseIndex(idx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ri.spans))
+
+	db := &DB{}
+	db.data.Store(&dataset{src: idx, reverse: ri})
+
+	n, err := db.Networks(City, "paris", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8.8.8.0/23", "8.8.10.0/24"}, cidrs(n.IPv4.CIDRs))
+
+	n, err = db.Networks(City, "Paris", "fr")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8.8.9.0/24", "8.8.10.0/24"}, cidrs(n.IPv4.CIDRs))
+
+	n, err = db.Networks(City, "Paris", "US")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8.8.8.0/24"}, cidrs(n.IPv4.CIDRs))
+
+	n, err = db.Networks(City, "Paris", "DE")
+	assert.NoError(t, err)
+	assert.Empty(t, n.IPv4.Ranges)
+}
+
+func Test_mergeSpans(t *testing.T) {
+	n := func(lo uint64) Uint128 { return Uint128{Lo: lo} }
+
+	assert.Equal(t, []span{{n(1), n(5)}, {n(7), n(9)}}, mergeSpans([]span{{n(7), n(8)}, {n(3), n(5)}, {n(1), n(2)}, {n(8), n(9)}}))
+	assert.Equal(t, []span{{n(0), hostMask(0)}}, mergeSpans([]span{{n(5), hostMask(0)}, {n(0), hostMask(0)}}))
+	assert.Empty(t, mergeSpans(nil))
+}
+
+func Test_prefixes(t *testing.T) {
+	n := func(s string) Uint128 { return uint128FromAddr(netip.MustParseAddr(s)) }
+	str := func(ps []netip.Prefix) (s []string) {
+		for _, p := range ps {
+			s = append(s, p.String())
+		}
+		return
+	}
+
+	assert.Equal(t, []string{"8.8.8.8/32"}, str(prefixes(n("8.8.8.8"), n("8.8.8.8"))))
+	assert.Equal(t, []string{"8.8.8.0/24"}, str(prefixes(n("8.8.8.0"), n("8.8.8.255"))))
+	assert.Equal(t, []string{"8.8.8.1/32", "8.8.8.2/31", "8.8.8.4/30", "8.8.8.8/32"}, str(prefixes(n("8.8.8.1"), n("8.8.8.8"))))
+	assert.Equal(t, []string{"0.0.0.0/0"}, str(prefixes(n("0.0.0.0"), n("255.255.255.255"))))
+	assert.Equal(t, []string{"::/0"}, str(prefixes(Uint128{}, hostMask(0))))
+	assert.Equal(t, []string{"::fffe:ffff:ffff/128", "0.0.0.0/32"}, str(prefixes(n("::fffe:ffff:ffff"), n("0.0.0.0"))))
+}
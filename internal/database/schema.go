@@ -0,0 +1,62 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// schemas are the columns of IP2Location databases after the IP numbers, by database type (DB1 to DB26).
+// BIN databases store Code and Country in one column, so the BIN column of the property at i > 0 is i+1.
+var schemas = [27][]Properties{
+	1:  {Code, Country},
+	2:  {Code, Country, ISP},
+	3:  {Code, Country, Region, City},
+	4:  {Code, Country, Region, City, ISP},
+	5:  {Code, Country, Region, City, Latitude, Longitude},
+	6:  {Code, Country, Region, City, Latitude, Longitude, ISP},
+	7:  {Code, Country, Region, City, ISP, Domain},
+	8:  {Code, Country, Region, City, Latitude, Longitude, ISP, Domain},
+	9:  {Code, Country, Region, City, Latitude, Longitude, ZipCode},
+	10: {Code, Country, Region, City, Latitude, Longitude, ZipCode, ISP, Domain},
+	11: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone},
+	12: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, ISP, Domain},
+	13: {Code, Country, Region, City, Latitude, Longitude, TimeZone, NetSpeed},
+	14: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, ISP, Domain, NetSpeed},
+	15: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, IDDCode, AreaCode},
+	16: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, ISP, Domain, NetSpeed, IDDCode, AreaCode},
+	17: {Code, Country, Region, City, Latitude, Longitude, TimeZone, NetSpeed, WeatherStationCode,
+		WeatherStationName},
+	18: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, ISP, Domain, NetSpeed, IDDCode, AreaCode,
+		WeatherStationCode, WeatherStationName},
+	19: {Code, Country, Region, City, Latitude, Longitude, ISP, Domain, MCC, MNC, MobileBrand},
+	20: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, ISP, Domain, NetSpeed, IDDCode, AreaCode,
+		WeatherStationCode, WeatherStationName, MCC, MNC, MobileBrand},
+	21: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, IDDCode, AreaCode, Elevation},
+	22: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, ISP, Domain, NetSpeed, IDDCode, AreaCode,
+		WeatherStationCode, WeatherStationName, MCC, MNC, MobileBrand, Elevation},
+	23: {Code, Country, Region, City, Latitude, Longitude, ISP, Domain, MCC, MNC, MobileBrand, UsageType},
+	24: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, ISP, Domain, NetSpeed, IDDCode, AreaCode,
+		WeatherStationCode, WeatherStationName, MCC, MNC, MobileBrand, Elevation, UsageType},
+	25: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, ISP, Domain, NetSpeed, IDDCode, AreaCode,
+		WeatherStationCode, WeatherStationName, MCC, MNC, MobileBrand, Elevation, UsageType, AddressType, Category},
+	26: {Code, Country, Region, City, Latitude, Longitude, ZipCode, TimeZone, ISP, Domain, NetSpeed, IDDCode, AreaCode,
+		WeatherStationCode, WeatherStationName, MCC, MNC, MobileBrand, Elevation, UsageType, AddressType, Category,
+		District, ASN, AS},
+}
+
+// dbType returns the database type of the IP2Location database code, like 11 for DB11LITEIPV6.
+func dbType(code string) (int, error) {
+	digits := strings.TrimPrefix(code, "DB")
+	end := 0
+	for end < len(digits) && digits[end] >= '0' && digits[end] <= '9' {
+		end++
+	}
+
+	t, err := strconv.Atoi(digits[:end])
+	if len(digits) == len(code) || err != nil || t < 1 || t >= len(schemas) {
+		return 0, fmt.Errorf("unsupported IP2Location database code %q", code)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_schemas(t *testing.T) {
+	for i, columns := range schemas[1:] {
+		assert.Equal(t, []Properties{Code, Country}, columns[:2], "DB%d", i+1)
+
+		// Columns are unique and in the order of allProperties
+		last := -1
+		for _, c := range columns {
+			pos := -1
+			for j, p := range allProperties {
+				if p == c {
+					pos = j
+				}
+			}
+			assert.Greater(t, pos, last, "DB%d: %s", i+1, c)
+			last = pos
+		}
+	}
+
+	// Every property is in DB26
+	assert.Equal(t, allProperties, schemas[26])
+}
+
+func Test_dbType(t *testing.T) {
+	for code, expected := range map[string]int{"DB1": 1, "DB11LITEIPV6": 11, "DB11LITEBINIPV6": 11, "DB26": 26,
+		"DB5LITECSV": 5, "DB24LITEBIN": 24} {
+		dt, err := dbType(code)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, dt, code)
+	}
+
+	// Errors
+	for _, code := range []string{"", "DB", "DB0", "DB27", "PX2LITE", "11LITE", "DBLITE"} {
+		_, err := dbType(code)
+		assert.Equal(t, `unsupported IP2Location database code "`+code+`"`, err.Error())
+	}
+}
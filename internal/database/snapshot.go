@@ -0,0 +1,180 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/ivanglie/iploc/internal/utils"
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+const (
+	snapshotsDir  = "snapshots" // Directory of snapshots in the data directory.
+	keepSnapshots = 3           // Number of snapshots kept by default.
+)
+
+// Snapshot is a version of the prepared data kept in the data directory.
+type Snapshot struct {
+	Manifest
+	Active bool `json:"active"`
+}
+
+// Snapshots returns the snapshots kept in the data directory dir, newest first.
+func Snapshots(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, snapshotsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	active := ""
+	if m, err := ReadManifest(dir); err == nil {
+		active = m.Snapshot
+	}
+
+	var snaps []Snapshot
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil || !e.IsDir() {
+			continue
+		}
+
+		// Snapshots without a manifest were not prepared completely.
+		m, err := ReadManifest(filepath.Join(dir, snapshotsDir, e.Name()))
+		if err != nil {
+			continue
+		}
+
+		snaps = append(snaps, Snapshot{Manifest: *m, Active: m.Snapshot == active})
+	}
+
+	sort.Slice(snaps, func(i, j int) bool {
+		a, _ := strconv.Atoi(snaps[i].Snapshot)
+		b, _ := strconv.Atoi(snaps[j].Snapshot)
+		return a > b
+	})
+
+	return snaps, nil
+}
+
+// newSnapshot keeps the archive zip as a new snapshot in the data directory dir
+// and returns its ID and the path of the kept archive. Snapshots are numbered from 1.
+// With link, the archive is hard linked when possible, so the snapshot takes no extra space.
+func newSnapshot(dir, zip string, link bool) (id, path string, err error) {
+	root := filepath.Join(dir, snapshotsDir)
+	if err = os.MkdirAll(root, 0755); err != nil {
+		return
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+
+	last := 0
+	for _, e := range entries {
+		if n, err := strconv.Atoi(e.Name()); err == nil && n > last {
+			last = n
+		}
+	}
+
+	id = strconv.Itoa(last + 1)
+	if err = os.Mkdir(filepath.Join(root, id), 0755); err != nil {
+		return
+	}
+
+	// Only archives replaced by renaming can be linked, others may be overwritten in place.
+	path = filepath.Join(root, id, filepath.Base(zip))
+	if link && os.Link(zip, path) == nil {
+		return
+	}
+
+	if err = utils.CopyFile(zip, path); err != nil {
+		return
+	}
+
+	info, err := os.Stat(zip)
+	if err != nil {
+		return
+	}
+
+	err = os.Chtimes(path, info.ModTime(), info.ModTime())
+	return
+}
+
+// pruneSnapshots removes the oldest snapshots in the data directory dir except the active one, keeping keep of them.
+func pruneSnapshots(dir string, keep int, l log.Logger) error {
+	snaps, err := Snapshots(dir)
+	if err != nil {
+		return err
+	}
+
+	for i, s := range snaps {
+		if i < keep || s.Active {
+			continue
+		}
+
+		l.Info(fmt.Sprintf("Remove snapshot %s...", s.Snapshot))
+		if err := os.RemoveAll(filepath.Join(dir, snapshotsDir, s.Snapshot)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rollback loads the snapshot id kept in the data directory, or the snapshot before the active one if id is empty,
+// without downloading anything, and makes it active.
+func (db *DB) Rollback(id string) error {
+	if !db.loading.TryLock() {
+		return errors.New("reload is already in progress")
+	}
+	defer db.loading.Unlock()
+
+	return db.track(func() error {
+		snaps, err := Snapshots(db.path)
+		if err != nil {
+			return err
+		}
+
+		target := -1
+		for i, s := range snaps {
+			if len(id) == 0 && s.Active && i+1 < len(snaps) {
+				target = i + 1
+			}
+			if len(id) != 0 && s.Snapshot == id {
+				target = i
+			}
+		}
+
+		if target < 0 && len(id) == 0 {
+			return errors.New("no snapshot before the active one")
+		}
+		if target < 0 {
+			return fmt.Errorf("snapshot %q not found", id)
+		}
+
+		m := snaps[target].Manifest
+		zip, err := m.check(db.path, db.Provider.Name())
+		if err != nil {
+			return err
+		}
+
+		db.logger().Info(fmt.Sprintf("Rollback to snapshot %s of %s...", m.Snapshot, m.Version.Format("2006-01-02")))
+		if err := db.parse(zip, m.Snapshot); err != nil {
+			return err
+		}
+
+		if err := m.write(db.path); err != nil {
+			return fmt.Errorf("writing manifest: %v", err)
+		}
+		db.logger().Info("Rollback completed")
+
+		return nil
+	})
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ivanglie/iploc/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Rollback(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, zipFileName)
+
+	ids := func() (ids []string, active string) {
+		snaps, err := Snapshots(dir)
+		assert.NoError(t, err)
+		for _, s := range snaps {
+			ids = append(ids, s.Snapshot)
+			if s.Active {
+				active = s.Snapshot
+			}
+		}
+		return
+	}
+
+	db := NewDB()
+	db.KeepSnapshots = 2
+	db.downloadFunc = sampleVersions(archive)
+	assert.NoError(t, db.Init("", "token", dir))
+	assert.NoError(t, db.Reload())
+	assert.NoError(t, db.Reload())
+
+	// The oldest snapshot is removed
+	snaps, active := ids()
+	assert.Equal(t, []string{"3", "2"}, snaps)
+	assert.Equal(t, "3", active)
+	assert.Equal(t, "3", db.Info().Dataset.Snapshot)
+
+	// To the previous snapshot
+	assert.NoError(t, db.Rollback(""))
+	_, active = ids()
+	assert.Equal(t, "2", active)
+	assert.Equal(t, "2", db.Info().Dataset.Snapshot)
+
+	m, err := ReadManifest(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", m.Snapshot)
+
+	loc, err := db.Search("8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, "Mountain View", loc.Properties[City])
+
+	// The rolled back snapshot is kept while not modified
+	db.downloadFunc = func(token, path string) (string, error) { return archive, errNotModified }
+	assert.NoError(t, db.Reload())
+	assert.Equal(t, "2", db.Info().Dataset.Snapshot)
+
+	// The active snapshot is not removed
+	assert.NoError(t, pruneSnapshots(dir, 1, log.Default()))
+	snaps, _ = ids()
+	assert.Equal(t, []string{"3", "2"}, snaps)
+
+	// To a given snapshot
+	assert.NoError(t, db.Rollback("3"))
+	_, active = ids()
+	assert.Equal(t, "3", active)
+
+	// Errors
+	assert.Equal(t, `snapshot "1" not found`, db.Rollback("1").Error())
+
+	assert.NoError(t, db.Rollback("2"))
+	assert.Equal(t, "no snapshot before the active one", db.Rollback("").Error())
+	assert.Equal(t, "2", db.Info().Dataset.Snapshot)
+
+	db.downloadFunc = func(token, path string) (string, error) {
+		return archive, replaceFile(archive, []byte("not a zip"))
+	}
+	assert.Error(t, db.Reload())
+	snaps, active = ids()
+	assert.Equal(t, []string{"3", "2"}, snaps)
+	assert.Equal(t, "2", active)
+
+	_, err = os.Stat(filepath.Join(dir, snapshotsDir, "4"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_Use(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, zipFileName)
+
+	db := NewDB()
+	db.downloadFunc = sampleVersions(archive)
+	assert.NoError(t, db.Init("", "token", dir))
+	assert.NoError(t, db.Reload())
+
+	// Rollback without the current dataset
+	db = NewDB()
+	db.Use("token", dir)
+	assert.Nil(t, db.Info().Dataset)
+	assert.NoError(t, db.Rollback(""))
+	assert.Equal(t, "1", db.Info().Dataset.Snapshot)
+}
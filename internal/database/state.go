@@ -0,0 +1,106 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// State of the database lifecycle.
+type State int32
+
+const (
+	Initializing State = iota // The first dataset is loading.
+	Ready                     // A dataset serves searches.
+	Updating                  // A dataset serves searches while a new one is loading.
+	Failed                    // No dataset is loaded, the first loading failed.
+)
+
+var stateNames = [...]string{Initializing: "initializing", Ready: "ready", Updating: "updating", Failed: "failed"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("State(%d)", int32(s))
+	}
+
+	return stateNames[s]
+}
+
+// MarshalText encodes the state by its name.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Info is the lifecycle state of the database and the metadata of the dataset serving searches.
+type Info struct {
+	State    State        `json:"state"`
+	Provider string       `json:"provider"`
+	Dataset  *DatasetInfo `json:"dataset,omitempty"` // Nil until a dataset is loaded.
+	Cache    *CacheStats  `json:"cache,omitempty"`   // Nil if the cache of lookups is disabled.
+	Error    string       `json:"error,omitempty"`   // Error of the last loading, if it failed.
+}
+
+// DatasetInfo is the metadata of a loaded dataset.
+type DatasetInfo struct {
+	Snapshot string    `json:"snapshot,omitempty"` // ID of the active snapshot in the data directory.
+	Archive  string    `json:"archive"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"` // Modification time of the archive, the Last-Modified time of the vendor when downloaded.
+	Loaded   time.Time `json:"loaded"`
+	Source   string    `json:"source"`
+}
+
+// Ready reports whether a dataset serves searches.
+func (i Info) Ready() bool {
+	return i.Dataset != nil
+}
+
+// Info returns the lifecycle state of the database and the metadata of its dataset.
+func (db *DB) Info() Info {
+	info := Info{State: State(db.state.Load()), Cache: db.CacheStats()}
+	if db.Provider != nil {
+		info.Provider = db.Provider.Name()
+	}
+
+	if ds := db.data.Load(); ds != nil {
+		info.Dataset = &DatasetInfo{
+			Snapshot: ds.snapshot,
+			Archive:  ds.zip,
+			Size:     ds.zipSize,
+			Modified: ds.modified,
+			Loaded:   ds.loaded,
+			Source:   fmt.Sprint(ds.src),
+		}
+	}
+
+	db.errMu.Lock()
+	defer db.errMu.Unlock()
+	if db.err != nil {
+		info.Error = db.err.Error()
+	}
+
+	return info
+}
+
+// track runs load in the initializing or updating state and records its error.
+// The database is ready afterwards if a dataset is loaded, old or new, and failed otherwise.
+func (db *DB) track(load func() error) error {
+	if db.data.Load() == nil {
+		db.state.Store(int32(Initializing))
+	} else {
+		db.state.Store(int32(Updating))
+	}
+
+	err := load()
+
+	db.errMu.Lock()
+	db.err = err
+	db.errMu.Unlock()
+
+	if db.data.Load() == nil {
+		db.state.Store(int32(Failed))
+	} else {
+		db.state.Store(int32(Ready))
+	}
+
+	return err
+}
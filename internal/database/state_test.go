@@ -0,0 +1,70 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ivanglie/iploc/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState_String(t *testing.T) {
+	assert.Equal(t, "initializing", Initializing.String())
+	assert.Equal(t, "ready", Ready.String())
+	assert.Equal(t, "updating", Updating.String())
+	assert.Equal(t, "failed", Failed.String())
+	assert.Equal(t, "State(7)", State(7).String())
+
+	b, err := json.Marshal(Info{State: Updating, Provider: "IP2Location-DB11LITEIPV6"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"state":"updating","provider":"IP2Location-DB11LITEIPV6"}`, string(b))
+}
+
+func TestDB_Info(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, zipFileName)
+
+	db := NewDB()
+	info := db.Info()
+	assert.Equal(t, Initializing, info.State)
+	assert.Equal(t, "IP2Location-DB11LITEIPV6", info.Provider)
+	assert.False(t, info.Ready())
+
+	// Failed without a dataset
+	db.downloadFunc = func(token, path string) (string, error) { return "", errors.New("download error") }
+	assert.Error(t, db.Init("", "token", dir))
+	info = db.Info()
+	assert.Equal(t, Failed, info.State)
+	assert.Equal(t, "downloading: download error", info.Error)
+	assert.False(t, info.Ready())
+
+	// Ready
+	db.downloadFunc = func(token, path string) (string, error) {
+		assert.Equal(t, Initializing, db.Info().State)
+		return archive, utils.CopyFile("../../test/data/"+zipFileName, archive)
+	}
+	assert.NoError(t, db.Reload())
+	info = db.Info()
+	assert.Equal(t, Ready, info.State)
+	assert.Empty(t, info.Error)
+	assert.True(t, info.Ready())
+	assert.Equal(t, "1", info.Dataset.Snapshot)
+	assert.Equal(t, filepath.Join(dir, "snapshots", "1", zipFileName), info.Dataset.Archive)
+	assert.Equal(t, int64(1254), info.Dataset.Size)
+	assert.False(t, info.Dataset.Modified.IsZero())
+	assert.False(t, info.Dataset.Loaded.IsZero())
+	assert.Equal(t, "index{ranges: 21, locations: 16}", info.Dataset.Source)
+
+	// Updating, and ready with the old dataset when the update fails
+	db.downloadFunc = func(token, path string) (string, error) {
+		assert.Equal(t, Updating, db.Info().State)
+		return "", errors.New("download error")
+	}
+	assert.Error(t, db.Reload())
+	info = db.Info()
+	assert.Equal(t, Ready, info.State)
+	assert.Equal(t, "downloading: download error", info.Error)
+	assert.Equal(t, "1", info.Dataset.Snapshot)
+}
@@ -0,0 +1,247 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Stats are the counts of ranges and addresses of a dataset, by country and region.
+type Stats struct {
+	Snapshot  string                   `json:"snapshot,omitempty"` // ID of the snapshot of the dataset.
+	Modified  time.Time                `json:"modified"`           // Modification time of the archive of the dataset.
+	Ranges    int                      `json:"ranges"`             // Ranges with a location.
+	IPv4      Coverage                 `json:"ipv4"`
+	IPv6      Coverage                 `json:"ipv6"`
+	Countries map[string]*CountryStats `json:"countries"` // Stats by country code.
+}
+
+// Coverage is the share of the address space of an IP version with a location.
+// IPv4 addresses are in ::ffff:0:0/96, other addresses are IPv6 ones.
+type Coverage struct {
+	Ranges       int     `json:"ranges"`        // Ranges with a location.
+	Addresses    Uint128 `json:"addresses"`     // Addresses with a location.
+	Ratio        float64 `json:"ratio"`         // Addresses with a location to all addresses.
+	Gaps         int     `json:"gaps"`          // Ranges without a location: unassigned ("-") or missing in the dataset.
+	GapAddresses Uint128 `json:"gap_addresses"` // Addresses without a location.
+}
+
+// CountryStats are the counts of ranges and addresses of a country.
+type CountryStats struct {
+	Country string                  `json:"country"`
+	Ranges  int                     `json:"ranges"`
+	IPv4    Uint128                 `json:"ipv4"` // Addresses.
+	IPv6    Uint128                 `json:"ipv6"` // Addresses.
+	Regions map[string]*RegionStats `json:"regions,omitempty"`
+}
+
+// RegionStats are the counts of ranges and addresses of a region.
+type RegionStats struct {
+	Ranges int     `json:"ranges"`
+	IPv4   Uint128 `json:"ipv4"` // Addresses.
+	IPv6   Uint128 `json:"ipv6"` // Addresses.
+}
+
+// coverage counts the ranges of an IP version, which are passed in ascending order.
+type coverage struct {
+	Coverage
+	first, last Uint128 // Bounds of the address space.
+	hole        *span   // Addresses of another IP version within the bounds, if any.
+	next        Uint128 // First address after the last range.
+	started     bool
+	done        bool // The last range ends at the last address.
+}
+
+// add counts the range from first to last. Missing addresses before it are a gap.
+func (c *coverage) add(first, last Uint128, located bool) {
+	from := c.next
+	if !c.started {
+		from = c.first
+	}
+	if first.cmp(from) > 0 {
+		c.gap(from, first.sub(1))
+	}
+
+	if located {
+		c.Ranges++
+		c.Addresses = c.Addresses.plus(last.minus(first).add(1))
+	} else {
+		c.gap(first, last)
+	}
+
+	c.next, c.started, c.done = last.add(1), true, last.cmp(c.last) >= 0
+}
+
+// gap counts the gap from first to last, leaving out the hole.
+func (c *coverage) gap(first, last Uint128) {
+	if h := c.hole; h != nil && first.cmp(h.last) <= 0 && last.cmp(h.first) >= 0 {
+		if first.cmp(h.first) < 0 {
+			c.gap(first, h.first.sub(1))
+		}
+		if last.cmp(h.last) > 0 {
+			c.gap(h.last.add(1), last)
+		}
+		return
+	}
+
+	c.Gaps++
+	c.GapAddresses = c.GapAddresses.plus(last.minus(first).add(1))
+}
+
+// close counts the gap after the last range and computes the ratio.
+func (c *coverage) close() Coverage {
+	switch {
+	case !c.started:
+		c.gap(c.first, c.last)
+	case !c.done:
+		c.gap(c.next, c.last)
+	}
+
+	// The size of the address space, 2^128 overflowing Uint128.
+	size := c.last.minus(c.first).float() + 1
+	if c.hole != nil {
+		size -= c.hole.last.minus(c.hole.first).float() + 1
+	}
+	c.Ratio = c.Addresses.float() / size
+
+	return c.Coverage
+}
+
+// newStats counts the ranges of s.
+// The IPv4 and IPv6 ranges are in ascending order in every source, although MMDB sources pass IPv4 ones first.
+func newStats(s Source) (*Stats, error) {
+	st := &Stats{Countries: map[string]*CountryStats{}}
+
+	v4First, v4Last := Uint128{Lo: 0xffff << 32}, Uint128{Lo: 0xffff<<32 | math.MaxUint32}
+	v4 := &coverage{first: v4First, last: v4Last}
+	v6 := &coverage{first: Uint128{}, last: hostMask(0), hole: &span{v4First, v4Last}}
+
+	err := s.ranges(func(first, last Uint128, p map[Properties]string) error {
+		code := p[Code]
+		located := len(code) != 0 && code != "-"
+
+		isV4 := first.cmp(v4First) >= 0 && last.cmp(v4Last) <= 0
+		if isV4 {
+			v4.add(first, last, located)
+		} else {
+			v6.add(first, last, located)
+		}
+
+		if !located {
+			return nil
+		}
+
+		st.Ranges++
+		n := last.minus(first).add(1)
+
+		c, ok := st.Countries[code]
+		if !ok {
+			c = &CountryStats{Country: p[Country]}
+			st.Countries[code] = c
+		}
+		c.Ranges++
+
+		var r *RegionStats
+		if region, ok := p[Region]; ok && region != "-" {
+			if c.Regions == nil {
+				c.Regions = map[string]*RegionStats{}
+			}
+			if r, ok = c.Regions[region]; !ok {
+				r = &RegionStats{}
+				c.Regions[region] = r
+			}
+			r.Ranges++
+		}
+
+		if isV4 {
+			c.IPv4 = c.IPv4.plus(n)
+			if r != nil {
+				r.IPv4 = r.IPv4.plus(n)
+			}
+		} else {
+			c.IPv6 = c.IPv6.plus(n)
+			if r != nil {
+				r.IPv6 = r.IPv6.plus(n)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	st.IPv4, st.IPv6 = v4.close(), v6.close()
+	return st, nil
+}
+
+// Stats returns the counts of ranges and addresses of the dataset, computed on loading.
+func (db *DB) Stats() (*Stats, error) {
+	ds := db.data.Load()
+	if ds == nil {
+		return nil, ErrNotLoaded
+	}
+
+	return ds.stats, nil
+}
+
+// SnapshotStats returns the counts of ranges and addresses of the snapshot id of the data directory,
+// those of the dataset if it is the loaded one. Other snapshots are parsed once and their counts are kept.
+func (db *DB) SnapshotStats(id string) (*Stats, error) {
+	if ds := db.data.Load(); ds != nil && ds.snapshot == id {
+		return ds.stats, nil
+	}
+
+	snaps, err := Snapshots(db.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *Manifest
+	for i := range snaps {
+		if snaps[i].Snapshot == id {
+			m = &snaps[i].Manifest
+		}
+	}
+	if m == nil {
+		return nil, fmt.Errorf("snapshot %q not found", id)
+	}
+
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+
+	// IDs of pruned snapshots may be reused, the archive identifies the snapshot.
+	key := id + "/" + m.SHA256
+	if st, ok := db.snapshotStats[key]; ok {
+		return st, nil
+	}
+
+	zip, err := m.check(db.path, db.Provider.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(zip)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := db.Provider.Parse(zip, db.logger())
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := newStats(src)
+	if err != nil {
+		return nil, fmt.Errorf("counting ranges: %v", err)
+	}
+	st.Snapshot, st.Modified = id, info.ModTime()
+
+	if db.snapshotStats == nil {
+		db.snapshotStats = map[string]*Stats{}
+	}
+	db.snapshotStats[key] = st
+
+	return st, nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Stats(t *testing.T) {
+	idx, err := loadIndex(schemas[11], "../../test/data/DB.CSV")
+	assert.NoError(t, err)
+
+	// The stats of all sources are the same
+	for _, src := range []Source{idx, testBIN(t, true)} {
+		st, err := newStats(src)
+		assert.NoError(t, err)
+
+		db := &DB{}
+		db.data.Store(&dataset{src: src, stats: st})
+
+		st, err = db.Stats()
+		assert.NoError(t, err)
+		assert.Equal(t, 18, st.Ranges)
+
+		assert.Equal(t, 10, st.IPv4.Ranges)
+		assert.Equal(t, "5632", st.IPv4.Addresses.String())
+		assert.Equal(t, 2, st.IPv4.Gaps)
+		assert.Equal(t, "4294961664", st.IPv4.GapAddresses.String())
+		assert.Equal(t, 5632.0/(1<<32), st.IPv4.Ratio)
+
+		// IPv4 addresses are not IPv6 gaps
+		assert.Equal(t, 8, st.IPv6.Ranges)
+		assert.Equal(t, 5, st.IPv6.Gaps)
+		assert.Equal(t, "340282366920938463463374607427473244160",
+			st.IPv6.Addresses.plus(st.IPv6.GapAddresses).String())
+
+		assert.Equal(t, 5, len(st.Countries))
+		assert.Equal(t, &CountryStats{Country: "Canada", Ranges: 1, IPv4: Uint128{Lo: 256},
+			Regions: map[string]*RegionStats{"Quebec": {Ranges: 1, IPv4: Uint128{Lo: 256}}}}, st.Countries["CA"])
+
+		us := st.Countries["US"]
+		assert.Equal(t, 14, us.Ranges)
+		assert.Equal(t, "5376", us.IPv4.String())
+		assert.Equal(t, &RegionStats{Ranges: 2, IPv4: Uint128{Lo: 3328}, IPv6: big2uint128("9671406556917033397649408")},
+			us.Regions["Louisiana"])
+		assert.Equal(t, "79228162514264337593543950336", us.Regions["Virginia"].IPv6.String())
+	}
+
+	// Addresses are strings in JSON
+	st, err := newStats(testMMDB(t))
+	assert.NoError(t, err)
+	b, err := json.Marshal(st.Countries["US"])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"country":"United States","ranges":1,"ipv4":"256","ipv6":"0",
+		"regions":{"California":{"ranges":1,"ipv4":"256","ipv6":"0"}}}`, string(b))
+
+	// Errors
+	_, err = (&DB{}).Stats()
+	assert.Equal(t, ErrNotLoaded, err)
+}
+
+func Test_coverage(t *testing.T) {
+	c := &coverage{first: Uint128{Lo: 0}, last: Uint128{Lo: 99}, hole: &span{Uint128{Lo: 40}, Uint128{Lo: 59}}}
+	c.add(Uint128{Lo: 10}, Uint128{Lo: 19}, true)
+	c.add(Uint128{Lo: 20}, Uint128{Lo: 29}, false)
+	c.add(Uint128{Lo: 70}, Uint128{Lo: 99}, true)
+
+	// Gaps are 0-9, 20-29, 30-39 and 60-69, leaving out the hole
+	assert.Equal(t, Coverage{Ranges: 2, Addresses: Uint128{Lo: 40}, Ratio: 0.5, Gaps: 4, GapAddresses: Uint128{Lo: 40}},
+		c.close())
+
+	// Nothing, 2^128 gap addresses overflow
+	c = &coverage{first: Uint128{Lo: 0}, last: hostMask(0)}
+	assert.Equal(t, Coverage{Gaps: 1, GapAddresses: Uint128{}}, c.close())
+}
+
+func TestDB_SnapshotStats(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, zipFileName)
+
+	db := NewDB()
+	db.downloadFunc = sampleVersions(archive)
+	assert.NoError(t, db.Init("", "token", dir))
+	assert.NoError(t, db.Reload())
+
+	// The loaded snapshot
+	active, err := db.Stats()
+	assert.NoError(t, err)
+	st, err := db.SnapshotStats("2")
+	assert.NoError(t, err)
+	assert.Same(t, active, st)
+
+	// Another snapshot is parsed once
+	st, err = db.SnapshotStats("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", st.Snapshot)
+	assert.Equal(t, active.Ranges, st.Ranges)
+	assert.Equal(t, active.Countries, st.Countries)
+
+	again, err := db.SnapshotStats("1")
+	assert.NoError(t, err)
+	assert.Same(t, st, again)
+
+	// Errors
+	_, err = db.SnapshotStats("5")
+	assert.Equal(t, `snapshot "5" not found`, err.Error())
+}
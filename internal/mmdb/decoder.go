@@ -0,0 +1,251 @@
+package mmdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Data types of the data section.
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEnd       = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// uintSizes are the maximum sizes of unsigned integer types.
+var uintSizes = map[int]uint{typeUint16: 2, typeUint32: 4, typeUint64: 8}
+
+// maxDepth limits nesting of decoded values to protect against malformed data.
+const maxDepth = 64
+
+// decoder decodes values of a data section.
+// Values are decoded to string, float64, float32, []byte, uint64, *big.Int, int32, bool,
+// []interface{} and map[string]interface{}.
+type decoder struct {
+	data []byte
+}
+
+// decode the value at offset and return it with the offset of the next value.
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("maximum data depth exceeded")
+	}
+
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		ptr, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		v, _, err := d.decode(ptr, depth+1)
+		return v, next, err
+	}
+
+	return d.value(typ, size, offset, depth)
+}
+
+// control reads the control byte at offset and returns the type and size of the value,
+// with the offset of its payload. For pointers, size holds the control byte.
+func (d *decoder) control(offset uint) (typ int, size uint, next uint, err error) {
+	if offset >= uint(len(d.data)) {
+		return 0, 0, 0, fmt.Errorf("unexpected end of data at %d", offset)
+	}
+
+	ctrl := d.data[offset]
+	offset++
+
+	typ = int(ctrl >> 5)
+	if typ == typePointer {
+		return typ, uint(ctrl), offset, nil
+	}
+
+	if typ == typeExtended {
+		if offset >= uint(len(d.data)) {
+			return 0, 0, 0, errors.New("unexpected end of data in extended type")
+		}
+
+		typ = 7 + int(d.data[offset])
+		offset++
+		if typ < typeInt32 || typ > typeFloat {
+			return 0, 0, 0, fmt.Errorf("invalid extended type %d", typ)
+		}
+	}
+
+	size = uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.data)) {
+			return 0, 0, 0, errors.New("unexpected end of data in size")
+		}
+
+		b := d.data[offset : offset+n]
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		default:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+	}
+
+	return typ, size, offset, nil
+}
+
+// pointer reads the pointer described by the control byte ctrl at offset
+// and returns the data section offset it points to with the offset after it.
+func (d *decoder) pointer(ctrl uint, offset uint) (uint, uint, error) {
+	n := (ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(d.data)) {
+		return 0, 0, errors.New("unexpected end of data in pointer")
+	}
+
+	var ptr uint
+	if n < 4 {
+		ptr = ctrl & 0x7
+	}
+
+	for _, b := range d.data[offset : offset+n] {
+		ptr = ptr<<8 | uint(b)
+	}
+
+	switch n {
+	case 2:
+		ptr += 2048
+	case 3:
+		ptr += 526336
+	}
+
+	return ptr, offset + n, nil
+}
+
+// value decodes a value of typ and size at offset.
+func (d *decoder) value(typ int, size, offset uint, depth int) (interface{}, uint, error) {
+	switch typ {
+	case typeMap:
+		return d.decodeMap(size, offset, depth)
+	case typeArray:
+		return d.decodeArray(size, offset, depth)
+	case typeBool:
+		if size > 1 {
+			return nil, 0, fmt.Errorf("invalid size %d of boolean", size)
+		}
+		return size == 1, offset, nil
+	case typeContainer, typeEnd:
+		return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+	}
+
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, fmt.Errorf("unexpected end of data for type %d of size %d", typ, size)
+	}
+
+	b := d.data[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte{}, b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid size %d of double", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid size %d of float", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid size %d of int32", size)
+		}
+		return int32(uintOf(b)), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > uintSizes[typ] {
+			return nil, 0, fmt.Errorf("invalid size %d of type %d", size, typ)
+		}
+		return uintOf(b), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("invalid size %d of uint128", size)
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+// decodeMap decodes size key-value pairs at offset.
+func (d *decoder) decodeMap(size, offset uint, depth int) (interface{}, uint, error) {
+	m := make(map[string]interface{})
+	for i := uint(0); i < size; i++ {
+		k, next, err := d.decode(offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		key, ok := k.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("map key of type %T is not a string", k)
+		}
+
+		var v interface{}
+		if v, offset, err = d.decode(next, depth+1); err != nil {
+			return nil, 0, err
+		}
+
+		m[key] = v
+	}
+
+	return m, offset, nil
+}
+
+// decodeArray decodes size values at offset.
+func (d *decoder) decodeArray(size, offset uint, depth int) (interface{}, uint, error) {
+	var a []interface{}
+	for i := uint(0); i < size; i++ {
+		v, next, err := d.decode(offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		a = append(a, v)
+		offset = next
+	}
+
+	return a, offset, nil
+}
+
+// uintOf returns the big-endian unsigned integer of b.
+func uintOf(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+
+	return n
+}
@@ -0,0 +1,137 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// encoder encodes values of a data section.
+type encoder struct {
+	buf bytes.Buffer
+}
+
+// encode writes v, which is a value of a type produced by decoder
+// or an int, uint, uint16, uint32, []string or map[string]string.
+func (e *encoder) encode(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		e.control(typeString, uint(len(v)))
+		e.buf.WriteString(v)
+	case []byte:
+		e.control(typeBytes, uint(len(v)))
+		e.buf.Write(v)
+	case float64:
+		e.control(typeDouble, 8)
+		binary.Write(&e.buf, binary.BigEndian, math.Float64bits(v))
+	case float32:
+		e.control(typeFloat, 4)
+		binary.Write(&e.buf, binary.BigEndian, math.Float32bits(v))
+	case bool:
+		n := uint(0)
+		if v {
+			n = 1
+		}
+		e.control(typeBool, n)
+	case int32:
+		e.uint(typeInt32, uint64(uint32(v)))
+	case int:
+		if v < 0 {
+			return fmt.Errorf("negative integer %d", v)
+		}
+		e.uint(typeUint64, uint64(v))
+	case uint:
+		e.uint(typeUint64, uint64(v))
+	case uint16:
+		e.uint(typeUint16, uint64(v))
+	case uint32:
+		e.uint(typeUint32, uint64(v))
+	case uint64:
+		e.uint(typeUint64, v)
+	case *big.Int:
+		if v.Sign() < 0 || v.BitLen() > 128 {
+			return fmt.Errorf("integer %v out of uint128 range", v)
+		}
+		b := v.Bytes()
+		e.control(typeUint128, uint(len(b)))
+		e.buf.Write(b)
+	case []string:
+		e.control(typeArray, uint(len(v)))
+		for _, s := range v {
+			e.encode(s)
+		}
+	case []interface{}:
+		e.control(typeArray, uint(len(v)))
+		for _, x := range v {
+			if err := e.encode(x); err != nil {
+				return err
+			}
+		}
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return e.encode(m)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		e.control(typeMap, uint(len(v)))
+		for _, k := range keys {
+			e.encode(k)
+			if err := e.encode(v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported value of type %T", v)
+	}
+
+	return nil
+}
+
+// uint writes n of the unsigned integer typ with leading zero bytes stripped.
+func (e *encoder) uint(typ int, n uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+
+	i := 0
+	for i < 8 && b[i] == 0 {
+		i++
+	}
+
+	e.control(typ, uint(8-i))
+	e.buf.Write(b[i:])
+}
+
+// control writes the control byte of typ and size.
+func (e *encoder) control(typ int, size uint) {
+	var ext []byte
+	if typ > typeMap {
+		ext = []byte{byte(typ - 7)}
+		typ = typeExtended
+	}
+
+	var extra []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		extra, size = []byte{byte(size - 29)}, 29
+	case size < 65821:
+		extra, size = []byte{byte((size - 285) >> 8), byte(size - 285)}, 30
+	default:
+		size -= 65821
+		extra, size = []byte{byte(size >> 16), byte(size >> 8), byte(size)}, 31
+	}
+
+	e.buf.WriteByte(byte(typ<<5) | byte(size))
+	e.buf.Write(ext)
+	e.buf.Write(extra)
+}
@@ -0,0 +1,269 @@
+// Package mmdb reads and writes MaxMind DB files.
+// See https://maxmind.github.io/MaxMind-DB/ for the format specification.
+package mmdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+)
+
+// metadataStart marks the beginning of the metadata section.
+var metadataStart = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparator is the size of the zero bytes between the search tree and the data section.
+const dataSectionSeparator = 16
+
+// Metadata of the database.
+type Metadata struct {
+	NodeCount    uint
+	RecordSize   uint
+	IPVersion    uint
+	DatabaseType string
+	Languages    []string
+	BuildEpoch   uint64
+	Description  map[string]string
+}
+
+// Reader looks up IP addresses in a MaxMind DB.
+type Reader struct {
+	Metadata Metadata
+
+	tree      []byte
+	data      decoder
+	ipv4Start uint // Node of the ::/96 subtree.
+}
+
+// Open reads the database from path.
+func Open(path string) (*Reader, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return FromBytes(b)
+}
+
+// FromBytes parses the database from b.
+func FromBytes(b []byte) (*Reader, error) {
+	i := bytes.LastIndex(b, metadataStart)
+	if i == -1 {
+		return nil, errors.New("invalid MaxMind DB file: metadata not found")
+	}
+
+	md := decoder{data: b[i+len(metadataStart):]}
+	v, _, err := md.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("decoding metadata: %v", err)
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+
+	r := &Reader{}
+	if err = r.Metadata.parse(m); err != nil {
+		return nil, err
+	}
+
+	treeSize := r.Metadata.NodeCount * r.Metadata.RecordSize / 4
+	if treeSize+dataSectionSeparator > uint(i) {
+		return nil, fmt.Errorf("search tree of %d nodes exceeds file size", r.Metadata.NodeCount)
+	}
+
+	r.tree = b[:treeSize]
+	r.data = decoder{data: b[treeSize+dataSectionSeparator : i]}
+
+	// IPv4 addresses are looked up in the ::/96 subtree of IPv6 databases.
+	if r.Metadata.IPVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.Metadata.NodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+
+	return r, nil
+}
+
+// parse metadata from the decoded map m.
+func (md *Metadata) parse(m map[string]interface{}) error {
+	u := func(key string) (uint, error) {
+		v, ok := m[key].(uint64)
+		if !ok {
+			return 0, fmt.Errorf("metadata %s is missing or invalid", key)
+		}
+		return uint(v), nil
+	}
+
+	var err error
+	if md.NodeCount, err = u("node_count"); err != nil {
+		return err
+	}
+
+	if md.RecordSize, err = u("record_size"); err != nil {
+		return err
+	}
+
+	if md.IPVersion, err = u("ip_version"); err != nil {
+		return err
+	}
+
+	switch md.RecordSize {
+	case 24, 28, 32:
+	default:
+		return fmt.Errorf("unsupported record size %d", md.RecordSize)
+	}
+
+	if md.IPVersion != 4 && md.IPVersion != 6 {
+		return fmt.Errorf("unsupported IP version %d", md.IPVersion)
+	}
+
+	md.DatabaseType, _ = m["database_type"].(string)
+	md.BuildEpoch, _ = m["build_epoch"].(uint64)
+
+	if langs, ok := m["languages"].([]interface{}); ok {
+		for _, l := range langs {
+			if s, ok := l.(string); ok {
+				md.Languages = append(md.Languages, s)
+			}
+		}
+	}
+
+	if desc, ok := m["description"].(map[string]interface{}); ok {
+		md.Description = make(map[string]string, len(desc))
+		for k, v := range desc {
+			md.Description[k], _ = v.(string)
+		}
+	}
+
+	return nil
+}
+
+// Networks calls fn for every network with a record until fn returns an error.
+// Networks of the ::/96 subtree of IPv6 databases are IPv4 networks, the networks aliasing it are skipped.
+func (r *Reader) Networks(fn func(network netip.Prefix, record interface{}) error) error {
+	bitCount := 128
+	if r.Metadata.IPVersion == 4 {
+		bitCount = 32
+	}
+
+	return r.walk(0, [16]byte{}, 0, bitCount, fn)
+}
+
+// walk calls fn for the networks under node at depth bits of ip.
+func (r *Reader) walk(node uint, ip [16]byte, depth, bitCount int, fn func(netip.Prefix, interface{}) error) error {
+	if node >= r.Metadata.NodeCount {
+		addr := netip.AddrFrom16(ip)
+		switch {
+		case bitCount == 32:
+			addr = netip.AddrFrom4([4]byte{ip[0], ip[1], ip[2], ip[3]})
+		case depth >= 96 && inIPv4Subtree(ip):
+			addr, depth = netip.AddrFrom4([4]byte{ip[12], ip[13], ip[14], ip[15]}), depth-96
+		}
+
+		rec, network, err := r.resolve(node, addr, depth)
+		if err != nil || rec == nil {
+			return err
+		}
+
+		return fn(network, rec)
+	}
+
+	if depth == bitCount {
+		return errors.New("invalid search tree: no record at maximum depth")
+	}
+
+	// Aliases of the IPv4 subtree
+	if node == r.ipv4Start && depth > 0 && (depth != 96 || !inIPv4Subtree(ip)) {
+		return nil
+	}
+
+	if err := r.walk(r.record(node, 0), ip, depth+1, bitCount, fn); err != nil {
+		return err
+	}
+
+	ip[depth>>3] |= 1 << (7 - uint(depth&7))
+	return r.walk(r.record(node, 1), ip, depth+1, bitCount, fn)
+}
+
+// inIPv4Subtree reports whether ip is in ::/96.
+func inIPv4Subtree(ip [16]byte) bool {
+	for _, b := range ip[:12] {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Lookup returns the record of addr and the network it belongs to.
+// The record is nil if addr is not in the database.
+func (r *Reader) Lookup(addr netip.Addr) (record interface{}, network netip.Prefix, err error) {
+	if !addr.IsValid() {
+		return nil, network, errors.New("invalid IP address")
+	}
+
+	addr = addr.Unmap()
+	if addr.Is6() && r.Metadata.IPVersion == 4 {
+		return nil, network, fmt.Errorf("IPv6 address %v in IPv4-only database", addr)
+	}
+
+	b, bitCount, node := addr.As16(), 128, uint(0)
+	if addr.Is4() {
+		b4 := addr.As4()
+		copy(b[:], b4[:])
+		bitCount, node = 32, r.ipv4Start
+	}
+
+	depth := 0
+	for ; depth < bitCount && node < r.Metadata.NodeCount; depth++ {
+		bit := (b[depth>>3] >> (7 - uint(depth&7))) & 1
+		node = r.record(node, uint(bit))
+	}
+
+	return r.resolve(node, addr, depth)
+}
+
+// resolve decodes the record of node found at depth bits of addr.
+func (r *Reader) resolve(node uint, addr netip.Addr, depth int) (interface{}, netip.Prefix, error) {
+	network, err := addr.Prefix(depth)
+	if err != nil {
+		return nil, network, err
+	}
+
+	switch {
+	case node == r.Metadata.NodeCount:
+		return nil, network, nil
+	case node < r.Metadata.NodeCount:
+		return nil, network, errors.New("invalid search tree: no record at maximum depth")
+	}
+
+	offset := node - r.Metadata.NodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data.data)) {
+		return nil, network, fmt.Errorf("invalid search tree: data pointer %d out of range", offset)
+	}
+
+	v, _, err := r.data.decode(offset, 0)
+	return v, network, err
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (r *Reader) record(node, bit uint) uint {
+	switch r.Metadata.RecordSize {
+	case 24:
+		o := node*6 + bit*3
+		return uint(r.tree[o])<<16 | uint(r.tree[o+1])<<8 | uint(r.tree[o+2])
+	case 28:
+		o := node * 7
+		if bit == 0 {
+			return uint(r.tree[o+3]&0xf0)<<20 | uint(r.tree[o])<<16 | uint(r.tree[o+1])<<8 | uint(r.tree[o+2])
+		}
+		return uint(r.tree[o+3]&0x0f)<<24 | uint(r.tree[o+4])<<16 | uint(r.tree[o+5])<<8 | uint(r.tree[o+6])
+	default:
+		o := node*8 + bit*4
+		return uint(r.tree[o])<<24 | uint(r.tree[o+1])<<16 | uint(r.tree[o+2])<<8 | uint(r.tree[o+3])
+	}
+}
@@ -0,0 +1,320 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// encode a value of the data section.
+func encode(v interface{}) []byte {
+	var buf bytes.Buffer
+
+	ctrl := func(typ int, size int) {
+		if typ > 7 {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(typ - 7))
+			return
+		}
+		buf.WriteByte(byte(typ<<5 | size))
+	}
+
+	switch v := v.(type) {
+	case string:
+		ctrl(typeString, len(v))
+		buf.WriteString(v)
+	case float64:
+		ctrl(typeDouble, 8)
+		binary.Write(&buf, binary.BigEndian, math.Float64bits(v))
+	case uint32:
+		ctrl(typeUint32, 4)
+		binary.Write(&buf, binary.BigEndian, v)
+	case int:
+		ctrl(typeUint16, 2)
+		binary.Write(&buf, binary.BigEndian, uint16(v))
+	case bool:
+		n := 0
+		if v {
+			n = 1
+		}
+		ctrl(typeBool, n)
+	case []interface{}:
+		ctrl(typeArray, len(v))
+		for _, e := range v {
+			buf.Write(encode(e))
+		}
+	case map[string]interface{}:
+		ctrl(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			buf.Write(encode(k))
+			buf.Write(encode(v[k]))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// build a database with 24-bit records from records by network.
+func build(ipVersion int, records map[string]interface{}) []byte {
+	type node struct{ children [2]int } // 0 is empty, > 0 is node+1, < 0 is -(data offset+1)
+
+	nodes := []node{{}}
+	var data bytes.Buffer
+	for prefix, rec := range records {
+		p := netip.MustParsePrefix(prefix)
+		b := p.Addr().As16()
+		bits := p.Bits()
+		if p.Addr().Is4() {
+			if ipVersion == 6 {
+				bits += 96
+				b = [16]byte{}
+				b4 := p.Addr().As4()
+				copy(b[12:], b4[:])
+			} else {
+				b4 := p.Addr().As4()
+				copy(b[:], b4[:])
+			}
+		}
+
+		offset := data.Len()
+		data.Write(encode(rec))
+
+		n := 0
+		for i := 0; i < bits; i++ {
+			bit := (b[i/8] >> (7 - i%8)) & 1
+			if i == bits-1 {
+				nodes[n].children[bit] = -(offset + 1)
+				break
+			}
+			if nodes[n].children[bit] <= 0 {
+				nodes = append(nodes, node{})
+				nodes[n].children[bit] = len(nodes)
+			}
+			n = nodes[n].children[bit] - 1
+		}
+	}
+
+	var out bytes.Buffer
+	count := len(nodes)
+	for _, n := range nodes {
+		for _, c := range n.children {
+			v := count
+			switch {
+			case c > 0:
+				v = c - 1
+			case c < 0:
+				v = count + 16 - c - 1
+			}
+			out.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+
+	out.Write(make([]byte, dataSectionSeparator))
+	out.Write(data.Bytes())
+	out.Write(metadataStart)
+	out.Write(encode(map[string]interface{}{
+		"node_count":    uint32(count),
+		"record_size":   24,
+		"ip_version":    ipVersion,
+		"database_type": "Test-City",
+		"languages":     []interface{}{"en"},
+		"build_epoch":   uint32(1676592000),
+		"description":   map[string]interface{}{"en": "Test database"},
+	}))
+
+	return out.Bytes()
+}
+
+var testRecords = map[string]interface{}{
+	"8.8.8.0/24": map[string]interface{}{
+		"city":    map[string]interface{}{"names": map[string]interface{}{"en": "Mountain View"}},
+		"country": map[string]interface{}{"iso_code": "US"},
+	},
+	"2001:4860::/32": map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "GB"},
+		"list":    []interface{}{"a", true, 1.5},
+	},
+}
+
+func TestFromBytes(t *testing.T) {
+	r, err := FromBytes(build(6, testRecords))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(24), r.Metadata.RecordSize)
+	assert.Equal(t, uint(6), r.Metadata.IPVersion)
+	assert.Equal(t, "Test-City", r.Metadata.DatabaseType)
+	assert.Equal(t, []string{"en"}, r.Metadata.Languages)
+	assert.Equal(t, uint64(1676592000), r.Metadata.BuildEpoch)
+	assert.Equal(t, map[string]string{"en": "Test database"}, r.Metadata.Description)
+
+	// Errors
+	_, err = FromBytes([]byte("not a database"))
+	assert.Equal(t, "invalid MaxMind DB file: metadata not found", err.Error())
+
+	_, err = FromBytes(append(append([]byte{}, metadataStart...), encode("string")...))
+	assert.Equal(t, "metadata is not a map", err.Error())
+
+	_, err = FromBytes(append(append([]byte{}, metadataStart...), encode(map[string]interface{}{"node_count": uint32(1)})...))
+	assert.Equal(t, "metadata record_size is missing or invalid", err.Error())
+
+	_, err = FromBytes(append(append([]byte{}, metadataStart...), encode(map[string]interface{}{
+		"node_count": uint32(1), "record_size": 20, "ip_version": 6})...))
+	assert.Equal(t, "unsupported record size 20", err.Error())
+
+	_, err = FromBytes(append(append([]byte{}, metadataStart...), encode(map[string]interface{}{
+		"node_count": uint32(10), "record_size": 24, "ip_version": 6})...))
+	assert.Equal(t, "search tree of 10 nodes exceeds file size", err.Error())
+
+	_, err = Open("not_found.mmdb")
+	assert.Equal(t, "open not_found.mmdb: no such file or directory", err.Error())
+}
+
+func TestReader_Lookup(t *testing.T) {
+	for _, ipVersion := range []int{4, 6} {
+		r, err := FromBytes(build(ipVersion, map[string]interface{}{"8.8.8.0/24": testRecords["8.8.8.0/24"]}))
+		assert.NoError(t, err)
+
+		for _, a := range []string{"8.8.8.8", "::ffff:8.8.8.8"} {
+			rec, network, err := r.Lookup(netip.MustParseAddr(a))
+			assert.NoError(t, err)
+			assert.Equal(t, netip.MustParsePrefix("8.8.8.0/24"), network)
+			assert.Equal(t, testRecords["8.8.8.0/24"], rec)
+		}
+
+		// Not found
+		rec, network, err := r.Lookup(netip.MustParseAddr("9.9.9.9"))
+		assert.NoError(t, err)
+		assert.Nil(t, rec)
+		assert.Equal(t, netip.MustParsePrefix("9.0.0.0/8"), network)
+	}
+
+	r, err := FromBytes(build(6, testRecords))
+	assert.NoError(t, err)
+
+	rec, network, err := r.Lookup(netip.MustParseAddr("2001:4860:4860::8888"))
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("2001:4860::/32"), network)
+	assert.Equal(t, testRecords["2001:4860::/32"], rec)
+
+	// Errors
+	_, _, err = r.Lookup(netip.Addr{})
+	assert.Equal(t, "invalid IP address", err.Error())
+
+	r, _ = FromBytes(build(4, map[string]interface{}{"8.8.8.0/24": "US"}))
+	_, _, err = r.Lookup(netip.MustParseAddr("2001:4860::1"))
+	assert.Equal(t, "IPv6 address 2001:4860::1 in IPv4-only database", err.Error())
+}
+
+func TestReader_Networks(t *testing.T) {
+	type network struct {
+		prefix string
+		rec    interface{}
+	}
+
+	collect := func(r *Reader) (networks []network) {
+		assert.NoError(t, r.Networks(func(p netip.Prefix, rec interface{}) error {
+			networks = append(networks, network{p.String(), rec})
+			return nil
+		}))
+		return
+	}
+
+	r, err := FromBytes(build(6, testRecords))
+	assert.NoError(t, err)
+	assert.Equal(t, []network{
+		{"8.8.8.0/24", testRecords["8.8.8.0/24"]},
+		{"2001:4860::/32", testRecords["2001:4860::/32"]},
+	}, collect(r))
+
+	r, err = FromBytes(build(4, map[string]interface{}{"8.8.8.0/24": "US", "1.1.1.0/24": "AU"}))
+	assert.NoError(t, err)
+	assert.Equal(t, []network{{"1.1.1.0/24", "AU"}, {"8.8.8.0/24", "US"}}, collect(r))
+
+	// Aliased networks are skipped
+	w := NewWriter("Test")
+	assert.NoError(t, w.Insert(netip.MustParsePrefix("8.8.8.0/24"), "US"))
+	assert.NoError(t, w.Insert(netip.MustParsePrefix("2c0f::/16"), "ZA"))
+	r = write(t, w)
+	assert.Equal(t, []network{{"8.8.8.0/24", "US"}, {"2c0f::/16", "ZA"}}, collect(r))
+
+	// Errors of fn are returned
+	err = r.Networks(func(netip.Prefix, interface{}) error { return errors.New("stop") })
+	assert.Equal(t, "stop", err.Error())
+}
+
+func Test_decoder(t *testing.T) {
+	// Pointers of all sizes
+	for _, p := range [][]byte{{0x20, 0x01}, {0x28, 0x00, 0x00}, {0x30, 0x00, 0x00, 0x00}, {0x38, 0x00, 0x00, 0x00, 0x01}} {
+		d := decoder{data: p}
+		ptr, next, err := d.pointer(uint(p[0]), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(len(p)), next)
+		assert.Equal(t, map[int]uint{2: 1, 3: 2048, 4: 526336, 5: 1}[len(p)], ptr)
+	}
+
+	// Pointer to a string
+	d := decoder{data: append(encode("foo"), 0x20, 0x00)}
+	v, next, err := d.decode(4, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", v)
+	assert.Equal(t, uint(6), next)
+
+	// Long strings
+	for _, n := range []int{29, 300, 70000} {
+		s := string(bytes.Repeat([]byte{'a'}, n))
+		var b []byte
+		switch {
+		case n < 285:
+			b = []byte{typeString<<5 | 29, byte(n - 29)}
+		case n < 65821:
+			b = []byte{typeString<<5 | 30, byte((n - 285) >> 8), byte(n - 285)}
+		default:
+			b = []byte{typeString<<5 | 31, byte((n - 65821) >> 16), byte((n - 65821) >> 8), byte(n - 65821)}
+		}
+
+		d = decoder{data: append(b, s...)}
+		v, _, err = d.decode(0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, s, v)
+	}
+
+	// Other types
+	d = decoder{data: []byte{
+		0x04, 0x01, 0xff, 0xff, 0xff, 0xfe, // int32
+		0x08, 0x02, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // uint64
+		0x04, 0x08, 0x3f, 0xc0, 0x00, 0x00, // float
+		0x82, 0x01, 0x02, // bytes
+	}}
+	next = 0
+	for _, expected := range []interface{}{int32(-2), uint64(1) << 56, float32(1.5), []byte{1, 2}} {
+		v, next, err = d.decode(next, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, v)
+	}
+
+	// Errors
+	_, _, err = (&decoder{data: []byte{}}).decode(0, 0)
+	assert.Equal(t, "unexpected end of data at 0", err.Error())
+
+	_, _, err = (&decoder{data: []byte{0x00, 0x10}}).decode(0, 0)
+	assert.Equal(t, "invalid extended type 23", err.Error())
+
+	_, _, err = (&decoder{data: []byte{0x65, 'a'}}).decode(0, 0)
+	assert.Equal(t, "unexpected end of data for type 3 of size 5", err.Error())
+
+	_, _, err = (&decoder{data: []byte{0xe1, 0xa1, 0x01}}).decode(0, 0)
+	assert.Equal(t, "map key of type uint64 is not a string", err.Error())
+
+	_, _, err = (&decoder{data: []byte{0x20, 0x00}}).decode(0, 0)
+	assert.Equal(t, "maximum data depth exceeded", err.Error())
+}
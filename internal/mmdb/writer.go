@@ -0,0 +1,306 @@
+package mmdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// aliases are the IPv6 networks which embed IPv4 addresses and resolve to the ::/96 subtree.
+var aliases = []netip.Prefix{
+	netip.MustParsePrefix("::ffff:0:0/96"), // IPv4-mapped
+	netip.MustParsePrefix("2001::/32"),     // Teredo
+	netip.MustParsePrefix("2002::/16"),     // 6to4
+}
+
+// Kinds of links.
+const (
+	linkEmpty = iota
+	linkNode
+	linkData
+)
+
+// link is the left or right record of a node: nothing, another node or a data offset.
+type link struct {
+	kind int
+	n    uint
+}
+
+type node struct {
+	links [2]link
+}
+
+// Writer builds an IPv6 MaxMind DB.
+// IPv4 networks are stored in the ::/96 subtree, which is aliased by IPv4-mapped,
+// Teredo and 6to4 networks. Data inserted inside aliased networks is replaced by the alias.
+type Writer struct {
+	DatabaseType string
+	Description  map[string]string
+	Languages    []string
+	BuildEpoch   uint64
+
+	nodes   []node
+	data    encoder
+	offsets map[string]uint // Offsets of encoded values in the data section.
+}
+
+// NewWriter returns an empty writer of databaseType.
+func NewWriter(databaseType string) *Writer {
+	return &Writer{DatabaseType: databaseType, nodes: []node{{}}, offsets: make(map[string]uint)}
+}
+
+// Insert the value of network. IPv4 and IPv4-mapped networks are inserted into the ::/96 subtree.
+func (w *Writer) Insert(network netip.Prefix, value interface{}) error {
+	if !network.IsValid() {
+		return errors.New("invalid network")
+	}
+
+	addr, bits := network.Addr(), network.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr, bits = addr.Unmap(), bits-96
+	}
+
+	if addr.Is4() {
+		b, b4 := [16]byte{}, addr.As4()
+		copy(b[12:], b4[:])
+		addr, bits = netip.AddrFrom16(b), bits+96
+	}
+
+	var e encoder
+	if err := e.encode(value); err != nil {
+		return fmt.Errorf("encoding value of %v: %v", network, err)
+	}
+
+	offset, ok := w.offsets[e.buf.String()]
+	if !ok {
+		offset = uint(w.data.buf.Len())
+		w.offsets[e.buf.String()] = offset
+		w.data.buf.Write(e.buf.Bytes())
+	}
+
+	w.set(addr.As16(), bits, link{kind: linkData, n: offset})
+	return nil
+}
+
+// InsertRange inserts the value of all addresses from first to last.
+func (w *Writer) InsertRange(first, last netip.Addr, value interface{}) error {
+	first, last = first.Unmap(), last.Unmap()
+	if !first.IsValid() || !last.IsValid() || first.BitLen() != last.BitLen() {
+		return fmt.Errorf("invalid range %v-%v", first, last)
+	}
+
+	if last.Less(first) {
+		return fmt.Errorf("range %v-%v is reversed", first, last)
+	}
+
+	for {
+		// The largest network starting at first and ending not after last.
+		bits := first.BitLen()
+		for bits > 0 {
+			p := netip.PrefixFrom(first, bits-1)
+			if p.Masked().Addr() != first || last.Less(lastAddr(p)) {
+				break
+			}
+			bits--
+		}
+
+		p := netip.PrefixFrom(first, bits)
+		if err := w.Insert(p, value); err != nil {
+			return err
+		}
+
+		end := lastAddr(p)
+		if end == last {
+			return nil
+		}
+		first = end.Next()
+	}
+}
+
+// lastAddr returns the last address of network.
+func lastAddr(network netip.Prefix) netip.Addr {
+	b, n := network.Addr().AsSlice(), network.Bits()
+	for i := range b {
+		switch {
+		case n >= 8:
+			n -= 8
+		case n > 0:
+			b[i] |= 0xff >> n
+			n = 0
+		default:
+			b[i] = 0xff
+		}
+	}
+
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+// bitAt returns bit i of the address b.
+func bitAt(b [16]byte, i int) int {
+	return int(b[i>>3]>>(7-uint(i&7))) & 1
+}
+
+// set the link of the network with bits of addr b, splitting the nodes on its path.
+func (w *Writer) set(b [16]byte, bits int, l link) {
+	if bits == 0 {
+		w.nodes[0].links = [2]link{l, l}
+		return
+	}
+
+	n := uint(0)
+	for depth := 0; depth < bits-1; depth++ {
+		n = w.split(n, bitAt(b, depth))
+	}
+
+	w.nodes[n].links[bitAt(b, bits-1)] = l
+}
+
+// split returns the child node of n at bit and creates it if the link is not a node.
+// A new node inherits the link, so the networks it covered keep their data.
+func (w *Writer) split(n uint, bit int) uint {
+	l := w.nodes[n].links[bit]
+	if l.kind == linkNode {
+		return l.n
+	}
+
+	w.nodes = append(w.nodes, node{links: [2]link{l, l}})
+	child := uint(len(w.nodes) - 1)
+	w.nodes[n].links[bit] = link{kind: linkNode, n: child}
+
+	return child
+}
+
+// alias links the aliased networks to the ::/96 subtree.
+func (w *Writer) alias() {
+	n := uint(0)
+	for depth := 0; depth < 95; depth++ {
+		n = w.split(n, 0)
+	}
+	ipv4 := w.nodes[n].links[0]
+
+	for _, a := range aliases {
+		w.set(a.Addr().As16(), a.Bits(), ipv4)
+	}
+}
+
+// merge replaces nodes with equal non-node links by the link and returns the link of l.
+// Results are memoized in merged, as aliased nodes have several parents.
+func (w *Writer) merge(l link, merged map[uint]link) link {
+	if l.kind != linkNode {
+		return l
+	}
+
+	if m, ok := merged[l.n]; ok {
+		return m
+	}
+
+	nd := &w.nodes[l.n]
+	nd.links[0] = w.merge(nd.links[0], merged)
+	nd.links[1] = w.merge(nd.links[1], merged)
+
+	m := l
+	if nd.links[0] == nd.links[1] && nd.links[0].kind != linkNode {
+		m = nd.links[0]
+	}
+	merged[l.n] = m
+
+	return m
+}
+
+// WriteTo writes the database to out.
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	w.alias()
+
+	merged := make(map[uint]link)
+	root := &w.nodes[0]
+	root.links[0] = w.merge(root.links[0], merged)
+	root.links[1] = w.merge(root.links[1], merged)
+
+	// Number reachable nodes in breadth-first order.
+	numbers, order := map[uint]uint{0: 0}, []uint{0}
+	for i := 0; i < len(order); i++ {
+		for _, l := range w.nodes[order[i]].links {
+			if _, ok := numbers[l.n]; l.kind == linkNode && !ok {
+				numbers[l.n] = uint(len(order))
+				order = append(order, l.n)
+			}
+		}
+	}
+
+	nodeCount := uint(len(order))
+	recordSize := uint(0)
+	for _, size := range []uint{24, 28, 32} {
+		if nodeCount+dataSectionSeparator+uint(w.data.buf.Len()) < 1<<size {
+			recordSize = size
+			break
+		}
+	}
+
+	if recordSize == 0 {
+		return 0, fmt.Errorf("database of %d nodes and %d bytes of data is too large", nodeCount, w.data.buf.Len())
+	}
+
+	var buf bytes.Buffer
+	for _, n := range order {
+		var r [2]uint
+		for i, l := range w.nodes[n].links {
+			switch l.kind {
+			case linkEmpty:
+				r[i] = nodeCount
+			case linkNode:
+				r[i] = numbers[l.n]
+			case linkData:
+				r[i] = nodeCount + dataSectionSeparator + l.n
+			}
+		}
+
+		switch recordSize {
+		case 24:
+			buf.Write([]byte{byte(r[0] >> 16), byte(r[0] >> 8), byte(r[0]),
+				byte(r[1] >> 16), byte(r[1] >> 8), byte(r[1])})
+		case 28:
+			buf.Write([]byte{byte(r[0] >> 16), byte(r[0] >> 8), byte(r[0]),
+				byte(r[0]>>24)<<4 | byte(r[1]>>24)&0x0f,
+				byte(r[1] >> 16), byte(r[1] >> 8), byte(r[1])})
+		default:
+			buf.Write([]byte{byte(r[0] >> 24), byte(r[0] >> 16), byte(r[0] >> 8), byte(r[0]),
+				byte(r[1] >> 24), byte(r[1] >> 16), byte(r[1] >> 8), byte(r[1])})
+		}
+	}
+
+	buf.Write(make([]byte, dataSectionSeparator))
+	buf.Write(w.data.buf.Bytes())
+	buf.Write(metadataStart)
+
+	languages := w.Languages
+	if languages == nil {
+		languages = []string{}
+	}
+
+	description := w.Description
+	if description == nil {
+		description = map[string]string{}
+	}
+
+	var md encoder
+	err := md.encode(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 w.BuildEpoch,
+		"database_type":               w.DatabaseType,
+		"description":                 description,
+		"ip_version":                  uint16(6),
+		"languages":                   languages,
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+	if err != nil {
+		return 0, err
+	}
+	buf.Write(md.buf.Bytes())
+
+	return buf.WriteTo(out)
+}
@@ -0,0 +1,190 @@
+package mmdb
+
+import (
+	"bytes"
+	"math/big"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func write(t *testing.T, w *Writer) *Reader {
+	var buf bytes.Buffer
+	_, err := w.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	r, err := FromBytes(buf.Bytes())
+	assert.NoError(t, err)
+
+	return r
+}
+
+func TestWriter(t *testing.T) {
+	w := NewWriter("Test-City")
+	w.Languages = []string{"en"}
+	w.Description = map[string]string{"en": "Test database"}
+	w.BuildEpoch = 1676592000
+	for prefix, rec := range testRecords {
+		assert.NoError(t, w.Insert(netip.MustParsePrefix(prefix), rec))
+	}
+
+	// IPv4-mapped networks are stored as IPv4
+	assert.NoError(t, w.Insert(netip.MustParsePrefix("::ffff:1.1.1.0/120"), "AU"))
+
+	// Data inside aliased networks is replaced
+	assert.NoError(t, w.Insert(netip.MustParsePrefix("2002::/24"), "aliased"))
+
+	r := write(t, w)
+	assert.Equal(t, Metadata{
+		NodeCount:    r.Metadata.NodeCount,
+		RecordSize:   24,
+		IPVersion:    6,
+		DatabaseType: "Test-City",
+		Languages:    []string{"en"},
+		BuildEpoch:   1676592000,
+		Description:  map[string]string{"en": "Test database"},
+	}, r.Metadata)
+
+	for _, tc := range []struct {
+		addr    string
+		network string
+		rec     interface{}
+	}{
+		{"8.8.8.8", "8.8.8.0/24", testRecords["8.8.8.0/24"]},
+		{"::ffff:8.8.8.8", "8.8.8.0/24", testRecords["8.8.8.0/24"]},
+		{"2001:4860:4860::8888", "2001:4860::/32", testRecords["2001:4860::/32"]},
+		{"1.1.1.1", "1.1.1.0/24", "AU"},
+		{"2002:808:808::", "2002:808:800::/40", testRecords["8.8.8.0/24"]},     // 6to4
+		{"2001:0:808:808::", "2001:0:808:800::/56", testRecords["8.8.8.0/24"]}, // Teredo
+		{"2002:909:909::", "2002:900::/24", nil},
+		{"9.9.9.9", "9.0.0.0/8", nil},
+	} {
+		rec, network, err := r.Lookup(netip.MustParseAddr(tc.addr))
+		assert.NoError(t, err, tc.addr)
+		assert.Equal(t, netip.MustParsePrefix(tc.network), network, tc.addr)
+		assert.Equal(t, tc.rec, rec, tc.addr)
+	}
+
+	// Equal values are stored once
+	w = NewWriter("Test")
+	assert.NoError(t, w.Insert(netip.MustParsePrefix("8.8.8.0/24"), "US"))
+	assert.NoError(t, w.Insert(netip.MustParsePrefix("2001:4860::/32"), "US"))
+	assert.Equal(t, 3, w.data.buf.Len())
+
+	// Errors
+	assert.Equal(t, "invalid network", w.Insert(netip.Prefix{}, "US").Error())
+	assert.Equal(t, "encoding value of 8.8.8.0/24: unsupported value of type int8",
+		w.Insert(netip.MustParsePrefix("8.8.8.0/24"), int8(1)).Error())
+}
+
+func TestWriter_InsertRange(t *testing.T) {
+	w := NewWriter("Test")
+	assert.NoError(t, w.InsertRange(netip.MustParseAddr("::ffff:10.0.0.1"), netip.MustParseAddr("::ffff:10.0.0.6"), "A"))
+	assert.NoError(t, w.InsertRange(netip.MustParseAddr("10.0.0.7"), netip.MustParseAddr("10.0.0.7"), "B"))
+	assert.NoError(t, w.InsertRange(netip.MustParseAddr("2c0f::"), netip.MustParseAddr("2c0f:ffff:ffff:ffff:ffff:ffff:ffff:ffff"), "C"))
+
+	r := write(t, w)
+	for _, tc := range []struct {
+		addr    string
+		network string
+		rec     interface{}
+	}{
+		{"10.0.0.0", "10.0.0.0/32", nil},
+		{"10.0.0.1", "10.0.0.1/32", "A"},
+		{"10.0.0.3", "10.0.0.2/31", "A"},
+		{"10.0.0.5", "10.0.0.4/31", "A"},
+		{"10.0.0.6", "10.0.0.6/32", "A"},
+		{"10.0.0.7", "10.0.0.7/32", "B"},
+		{"2c0f:1::", "2c0f::/16", "C"},
+	} {
+		rec, network, err := r.Lookup(netip.MustParseAddr(tc.addr))
+		assert.NoError(t, err, tc.addr)
+		assert.Equal(t, netip.MustParsePrefix(tc.network), network, tc.addr)
+		assert.Equal(t, tc.rec, rec, tc.addr)
+	}
+
+	// Whole address spaces
+	w = NewWriter("Test")
+	assert.NoError(t, w.InsertRange(netip.MustParseAddr("0.0.0.0"), netip.MustParseAddr("255.255.255.255"), "A"))
+	r = write(t, w)
+	rec, network, err := r.Lookup(netip.MustParseAddr("8.8.8.8"))
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("0.0.0.0/0"), network)
+	assert.Equal(t, "A", rec)
+
+	// Errors
+	assert.Equal(t, "range 10.0.0.2-10.0.0.1 is reversed",
+		w.InsertRange(netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1"), "A").Error())
+	assert.Equal(t, "invalid range 10.0.0.1-2c0f::",
+		w.InsertRange(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("2c0f::"), "A").Error())
+}
+
+func TestWriter_WriteTo_recordSize(t *testing.T) {
+	for _, tc := range []struct {
+		size       int
+		recordSize uint
+	}{
+		{1 << 20, 24},
+		{1 << 24, 28},
+	} {
+		w := NewWriter("Test")
+		assert.NoError(t, w.Insert(netip.MustParsePrefix("8.8.8.0/24"), make([]byte, tc.size)))
+		assert.NoError(t, w.Insert(netip.MustParsePrefix("9.9.9.0/24"), "US"))
+
+		r := write(t, w)
+		assert.Equal(t, tc.recordSize, r.Metadata.RecordSize)
+
+		rec, _, err := r.Lookup(netip.MustParseAddr("9.9.9.9"))
+		assert.NoError(t, err)
+		assert.Equal(t, "US", rec)
+	}
+}
+
+func Test_encoder(t *testing.T) {
+	for _, v := range []interface{}{
+		"",
+		string(bytes.Repeat([]byte{'a'}, 29)),
+		string(bytes.Repeat([]byte{'a'}, 300)),
+		string(bytes.Repeat([]byte{'a'}, 70000)),
+		1.5,
+		float32(-2.5),
+		[]byte{1, 2},
+		true,
+		false,
+		int32(-2),
+		uint64(0),
+		uint64(1) << 56,
+		new(big.Int).Lsh(big.NewInt(1), 100),
+		[]interface{}{"a", true},
+		map[string]interface{}{"b": "x", "a": []interface{}{"y"}},
+	} {
+		var e encoder
+		assert.NoError(t, e.encode(v))
+
+		d := decoder{data: e.buf.Bytes()}
+		decoded, next, err := d.decode(0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, v, decoded)
+		assert.Equal(t, uint(e.buf.Len()), next)
+	}
+
+	// Converted types
+	for v, expected := range map[interface{}]interface{}{
+		1:         uint64(1),
+		uint(2):   uint64(2),
+		uint16(3): uint64(3),
+		uint32(4): uint64(4),
+	} {
+		var e encoder
+		assert.NoError(t, e.encode(v))
+		decoded, _, err := (&decoder{data: e.buf.Bytes()}).decode(0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, decoded)
+	}
+
+	// Errors
+	var e encoder
+	assert.Equal(t, "negative integer -1", e.encode(-1).Error())
+	assert.Equal(t, "unsupported value of type []int", e.encode([]int{1}).Error())
+}
@@ -0,0 +1,311 @@
+// Package schedule runs a job periodically, at an interval or by a cron expression.
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+// Schedule returns the next time of a run after t.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// Parse parses spec, which is either an interval like 720h
+// or a cron expression of 5 fields (minute, hour, day of month, month, day of week) like "0 3 1 * *".
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("interval %v is not positive", d)
+		}
+		return interval(d), nil
+	}
+
+	return parseCron(spec)
+}
+
+// interval runs every d.
+type interval time.Duration
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// cron runs at the times matching all of its fields, except that the day matches
+// either day of month or day of week when both are restricted, as in crontab.
+type cron struct {
+	minute, hour, dom, month, dow uint64 // Bit sets of allowed values.
+	domAny, dowAny                bool
+}
+
+// cronFields are the bounds of the fields of a cron expression.
+var cronFields = [5]struct {
+	name     string
+	min, max int
+}{{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7}}
+
+func parseCron(spec string) (*cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("schedule %q is neither an interval nor a cron expression of 5 fields", spec)
+	}
+
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseField(f, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("%s of %q: %v", cronFields[i].name, spec, err)
+		}
+		sets[i] = set
+	}
+
+	// Sunday is both 0 and 7.
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &cron{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseField parses a comma-separated list of *, values, ranges (a-b) and steps (*/n, a-b/n).
+func parseField(f string, min, max int) (set uint64, err error) {
+	for _, part := range strings.Split(f, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		from, to := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+			if to, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			if from, err = strconv.Atoi(rng); err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			to = from
+			if strings.Contains(part, "/") {
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q is out of range %d-%d", rng, min, max)
+		}
+
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return
+}
+
+// Next returns the first minute after t matching the expression, or the zero time if there is none within 5 years.
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+
+	for t.Before(end) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c *cron) matchDay(t time.Time) bool {
+	dom, dow := c.dom&(1<<uint(t.Day())) != 0, c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// state is persisted between restarts.
+type state struct {
+	LastSuccess time.Time `json:"last_success"`
+}
+
+// retryBackoff is the delay of the first retry of a failed run, doubled before each next one.
+const retryBackoff = 5 * time.Minute
+
+// Scheduler runs Job by Schedule with a random delay of up to Jitter,
+// so that instances do not hit the vendor at the same moment.
+// A failed run is retried after Retry, doubled after each failure, but not later than the next regular run.
+// The time of the last successful run is persisted to StatePath, if set. Runs are logged to Log,
+// the logger of pkg/log if nil.
+type Scheduler struct {
+	Schedule  Schedule
+	Jitter    time.Duration
+	Retry     time.Duration
+	StatePath string
+	Job       func() error
+	Log       log.Logger
+
+	mu          sync.Mutex
+	lastSuccess time.Time
+}
+
+// New returns a scheduler of job and restores the time of its last successful run from statePath.
+func New(s Schedule, jitter time.Duration, statePath string, job func() error) (*Scheduler, error) {
+	sc := &Scheduler{Schedule: s, Jitter: jitter, Retry: retryBackoff, StatePath: statePath, Job: job}
+	if len(statePath) == 0 {
+		return sc, nil
+	}
+
+	b, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return sc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("reading state %s: %v", statePath, err)
+	}
+	sc.lastSuccess = st.LastSuccess
+
+	return sc, nil
+}
+
+// LastSuccess returns the time of the last successful run, zero if there was none.
+func (s *Scheduler) LastSuccess() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastSuccess
+}
+
+// Due reports whether a run is due at now, which is when there was no successful run
+// or the next run after the last successful one is not in the future.
+func (s *Scheduler) Due(now time.Time) bool {
+	last := s.LastSuccess()
+	return last.IsZero() || !s.Schedule.Next(last).After(now)
+}
+
+// Succeeded records a successful run at t, made by Run or outside of it (like the initial download).
+func (s *Scheduler) Succeeded(t time.Time) error {
+	s.mu.Lock()
+	s.lastSuccess = t
+	s.mu.Unlock()
+
+	if len(s.StatePath) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(state{LastSuccess: t})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.StatePath, b, 0644)
+}
+
+// Run runs the job until done is closed. The first run is scheduled after the last successful one,
+// so a restart does not run the job again before it is due. Later runs are scheduled after the previous run,
+// failed runs are retried sooner.
+func (s *Scheduler) Run(done <-chan struct{}) {
+	from := s.LastSuccess()
+	if from.IsZero() {
+		from = time.Now()
+	}
+
+	l := s.Log
+	if l == nil {
+		l = log.Default()
+	}
+
+	next := s.Schedule.Next(from)
+	for failures := 0; ; {
+		if next.IsZero() {
+			return
+		}
+
+		delay := time.Until(next)
+		if s.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(s.Jitter)))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		from = time.Now()
+		l.Info("Scheduled run...")
+		if err := s.Job(); err != nil {
+			failures++
+			next = s.retry(from, failures)
+			l.Error(fmt.Sprintf("scheduled run: %v, retry after %s", err, next.Format(time.RFC3339)))
+			continue
+		}
+
+		failures = 0
+		next = s.Schedule.Next(from)
+		if err := s.Succeeded(from); err != nil {
+			l.Error(fmt.Sprintf("saving state: %v", err))
+			continue
+		}
+		l.Info(fmt.Sprintf("Scheduled run completed, next after %s", next.Format(time.RFC3339)))
+	}
+}
+
+// retry returns the time of the retry of a run started at t after failures consecutive failures:
+// after Retry doubled for each failure but the first, but not later than the next regular run.
+func (s *Scheduler) retry(t time.Time, failures int) time.Time {
+	regular := s.Schedule.Next(t)
+	if s.Retry <= 0 {
+		return regular
+	}
+
+	d := s.Retry
+	for i := 1; i < failures && d < 1<<62; i++ {
+		d *= 2
+	}
+
+	if at := t.Add(d); regular.IsZero() || at.Before(regular) {
+		return at
+	}
+
+	return regular
+}
@@ -0,0 +1,151 @@
+package schedule
+
+import (
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse("720h")
+	assert.NoError(t, err)
+	assert.Equal(t, interval(720*time.Hour), s)
+
+	// Errors
+	for spec, msg := range map[string]string{
+		"-1h":         "interval -1h0m0s is not positive",
+		"monthly":     `schedule "monthly" is neither an interval nor a cron expression of 5 fields`,
+		"60 * * * *":  `minute of "60 * * * *": "60" is out of range 0-59`,
+		"* * 0 * *":   `day of month of "* * 0 * *": "0" is out of range 1-31`,
+		"* * * 1-x *": `month of "* * * 1-x *": invalid range "1-x"`,
+		"*/0 * * * *": `minute of "*/0 * * * *": invalid step in "*/0"`,
+		"* 5-3 * * *": `hour of "* 5-3 * * *": "5-3" is out of range 0-23`,
+	} {
+		_, err := Parse(spec)
+		assert.Equal(t, msg, err.Error(), spec)
+	}
+}
+
+func TestCron_Next(t *testing.T) {
+	from := time.Date(2023, 2, 17, 10, 30, 15, 0, time.UTC) // Friday
+
+	for spec, next := range map[string]time.Time{
+		"* * * * *":        time.Date(2023, 2, 17, 10, 31, 0, 0, time.UTC),
+		"0 3 1 * *":        time.Date(2023, 3, 1, 3, 0, 0, 0, time.UTC),
+		"*/15 * * * *":     time.Date(2023, 2, 17, 10, 45, 0, 0, time.UTC),
+		"5,50 9-11 * * *":  time.Date(2023, 2, 17, 10, 50, 0, 0, time.UTC),
+		"0 0 * * 0":        time.Date(2023, 2, 19, 0, 0, 0, 0, time.UTC),
+		"0 0 * * 7":        time.Date(2023, 2, 19, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":       time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		"0 0 1 * 1":        time.Date(2023, 2, 20, 0, 0, 0, 0, time.UTC), // Either day of month or day of week
+		"0 12 10-20/5 * *": time.Date(2023, 2, 20, 12, 0, 0, 0, time.UTC),
+		"0 0 31 2 *":       {},
+	} {
+		s, err := Parse(spec)
+		assert.NoError(t, err)
+		assert.Equal(t, next, s.Next(from), spec)
+	}
+}
+
+func TestScheduler_state(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := New(interval(time.Hour), 0, path, nil)
+	assert.NoError(t, err)
+	assert.True(t, s.LastSuccess().IsZero())
+	assert.True(t, s.Due(time.Now()))
+
+	last := time.Date(2023, 2, 17, 10, 30, 0, 0, time.UTC)
+	assert.NoError(t, s.Succeeded(last))
+	assert.False(t, s.Due(last.Add(59*time.Minute)))
+	assert.True(t, s.Due(last.Add(time.Hour)))
+
+	// Restored after restart
+	s, err = New(interval(time.Hour), 0, path, nil)
+	assert.NoError(t, err)
+	assert.True(t, last.Equal(s.LastSuccess()))
+
+	// Errors
+	_, err = New(interval(time.Hour), 0, t.TempDir(), nil)
+	assert.Error(t, err)
+}
+
+func TestScheduler_Run(t *testing.T) {
+	var runs int32
+	s, err := New(interval(10*time.Millisecond), time.Millisecond, filepath.Join(t.TempDir(), "state.json"), func() error {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			return errors.New("job error")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(done)
+		close(stopped)
+	}()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&runs) >= 3 }, time.Second, time.Millisecond)
+	close(done)
+	<-stopped
+
+	assert.False(t, s.LastSuccess().IsZero())
+}
+
+func TestScheduler_Run_retry(t *testing.T) {
+	var runs int32
+	s, err := New(interval(time.Hour), 0, "", func() error {
+		if atomic.AddInt32(&runs, 1) <= 2 {
+			return errors.New("job error")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	s.Retry = time.Millisecond
+	assert.NoError(t, s.Succeeded(time.Now().Add(-2*time.Hour)))
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(done)
+		close(stopped)
+	}()
+
+	// Failed runs are retried long before the next regular run
+	assert.Eventually(t, func() bool { return time.Since(s.LastSuccess()) < time.Minute }, time.Second, time.Millisecond)
+	close(done)
+	<-stopped
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&runs))
+}
+
+func TestScheduler_retry(t *testing.T) {
+	from := time.Date(2023, 2, 17, 10, 30, 0, 0, time.UTC)
+	s, err := New(interval(time.Hour), 0, "", nil)
+	assert.NoError(t, err)
+	s.Retry = time.Minute
+
+	assert.Equal(t, from.Add(time.Minute), s.retry(from, 1))
+	assert.Equal(t, from.Add(2*time.Minute), s.retry(from, 2))
+	assert.Equal(t, from.Add(32*time.Minute), s.retry(from, 6))
+
+	// Capped by the next regular run
+	assert.Equal(t, from.Add(time.Hour), s.retry(from, 7))
+	assert.Equal(t, from.Add(time.Hour), s.retry(from, 1000))
+
+	// Monthly schedule
+	c, err := Parse("0 3 1 * *")
+	assert.NoError(t, err)
+	s.Schedule = c
+	assert.Equal(t, from.Add(time.Minute), s.retry(from, 1))
+	assert.Equal(t, time.Date(2023, 3, 1, 3, 0, 0, 0, time.UTC), s.retry(from, 1000))
+
+	// Without retries
+	s.Retry = 0
+	assert.Equal(t, time.Date(2023, 3, 1, 3, 0, 0, 0, time.UTC), s.retry(from, 1))
+}
@@ -1,150 +1,114 @@
 package utils
 
 import (
+	"archive/tar"
 	"archive/zip"
-	"bufio"
-	"bytes"
-	"errors"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 	"strings"
 )
 
-func SplitCSV(filePath string, fileSize, bufferSize int64) ([]string, error) {
-	chunks := []string{}
-
-	if len(filePath) == 0 {
-		return chunks, errors.New("empty db.csv")
-	}
-
-	if fileSize == 0 {
-		return chunks, errors.New("db.csvSize is 0")
-	}
-
-	if bufferSize == 0 {
-		return chunks, errors.New("bufferSize is 0")
+func CopyFile(src, dst string) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
 	}
+	defer s.Close()
 
-	file, err := os.Open(filePath)
+	d, err := os.Create(dst)
 	if err != nil {
-		return chunks, err
+		return err
 	}
-	defer file.Close()
-
-	buffer := make([]byte, bufferSize)
-	head := []byte{}
-	i := 0
-	for {
-		count, err := file.Read(buffer)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return chunks, err
-		}
-
-		chunk := append(head, buffer[:count]...)
-		count = len(chunk)
-
-		if index := bytes.LastIndex(chunk, []byte{'\n'}); index > -1 {
-			head = chunk[index+1 : count]
-			chunk = chunk[:index]
-		} else {
-			head = nil
-		}
-
-		i++
-		np, _ := filepath.Abs(fmt.Sprintf("%s_%04d.CSV", strings.TrimSuffix(filePath, ".CSV"), i))
-		err = os.WriteFile(np, chunk, 0777)
-		if err != nil {
-			return chunks, err
-		}
+	defer d.Close()
 
-		chunks = append(chunks, np)
-	}
+	_, err = io.Copy(d, s)
+	return err
+}
 
-	return chunks, nil
+// readCloser reads from Reader and closes with close.
+type readCloser struct {
+	io.Reader
+	close func() error
 }
 
-func UnzipCSV(filePath string) (string, error) {
-	csvFilePath := ""
+func (r *readCloser) Close() error {
+	return r.close()
+}
 
+// OpenZip opens the first file of the zip archive at filePath whose name has suffix (in any case) for reading,
+// without extracting it. Closing the reader closes the archive. The size is the uncompressed size of the file.
+func OpenZip(filePath, suffix string) (rc io.ReadCloser, size int64, err error) {
 	if len(filePath) == 0 {
-		return csvFilePath, fmt.Errorf("empty filePath")
+		return nil, 0, fmt.Errorf("empty filePath")
 	}
 
 	zr, err := zip.OpenReader(filePath)
 	if err != nil {
-		return csvFilePath, err
+		return nil, 0, err
 	}
-	defer zr.Close()
 
 	for _, f := range zr.File {
-		if f.Name[len(f.Name)-4:] != ".CSV" {
+		if !strings.HasSuffix(strings.ToUpper(f.Name), strings.ToUpper(suffix)) {
 			continue
 		}
 
-		var in io.ReadCloser
-		if in, err = f.Open(); err != nil {
-			return csvFilePath, err
-		}
-		defer in.Close()
-
-		csvFilePath = filepath.Join(filepath.Dir(filePath), f.Name)
-
-		var out *os.File
-		if out, err = os.Create(csvFilePath); err != nil {
-			return csvFilePath, err
-		}
-		defer out.Close()
-
-		r := bufio.NewReader(in)
-		for {
-			var line []byte
-			if line, _, err = r.ReadLine(); err == io.EOF {
-				break
-			}
-			if err != nil {
-				return csvFilePath, err
-			}
-
-			if _, err = fmt.Fprintln(out, string(line)); err != nil {
-				return csvFilePath, err
-			}
+		in, err := f.Open()
+		if err != nil {
+			zr.Close()
+			return nil, 0, err
 		}
-	}
 
-	if csvFilePath == "" {
-		return csvFilePath, fmt.Errorf("no CSV file found in the zip archive")
+		return &readCloser{Reader: in, close: func() error {
+			in.Close()
+			return zr.Close()
+		}}, int64(f.UncompressedSize64), nil
 	}
 
-	return csvFilePath, nil
+	zr.Close()
+	return nil, 0, fmt.Errorf("no %s file found in the zip archive", strings.ToUpper(strings.TrimPrefix(suffix, ".")))
 }
 
-func CopyFile(src, dst string) error {
-	s, err := os.Open(src)
+// OpenTarGz opens the first regular file of the tar.gz archive at filePath whose name has suffix (in any case)
+// for reading, without extracting it. Closing the reader closes the archive.
+func OpenTarGz(filePath, suffix string) (rc io.ReadCloser, size int64, err error) {
+	if len(filePath) == 0 {
+		return nil, 0, fmt.Errorf("empty filePath")
+	}
+
+	f, err := os.Open(filePath)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	defer s.Close()
 
-	d, err := os.Create(dst)
+	gz, err := gzip.NewReader(f)
 	if err != nil {
-		return err
+		f.Close()
+		return nil, 0, err
 	}
-	defer d.Close()
 
-	_, err = io.Copy(d, s)
-	return err
-}
+	closeAll := func() error {
+		gz.Close()
+		return f.Close()
+	}
 
-func FileSize(filePath string) (int64, error) {
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
-		return 0, err
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			closeAll()
+			return nil, 0, err
+		}
+
+		if h.Typeflag == tar.TypeReg && strings.HasSuffix(strings.ToUpper(h.Name), strings.ToUpper(suffix)) {
+			return &readCloser{Reader: tr, close: closeAll}, h.Size, nil
+		}
 	}
 
-	return fileInfo.Size(), nil
+	closeAll()
+	return nil, 0, fmt.Errorf("no %s file found in the tar.gz archive", strings.ToUpper(strings.TrimPrefix(suffix, ".")))
 }
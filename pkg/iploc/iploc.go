@@ -0,0 +1,413 @@
+// Package iploc looks up the geolocation of IP addresses in IP2Location and MaxMind databases.
+// Databases are downloaded to a data directory, reused across restarts, and updated by a reload policy.
+package iploc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ivanglie/iploc/internal/database"
+	"github.com/ivanglie/iploc/internal/schedule"
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+// Database vendors.
+const (
+	IP2Location = database.IP2Location
+	MaxMind     = database.MaxMind
+)
+
+// Lifecycle states of the database.
+const (
+	Initializing = database.Initializing
+	Ready        = database.Ready
+	Updating     = database.Updating
+	Failed       = database.Failed
+)
+
+type (
+	// State of the database lifecycle.
+	State = database.State
+
+	// Info is the lifecycle state of the database and the metadata of the dataset serving lookups.
+	Info = database.Info
+
+	// Location is the typed location of a netblock with its network and range. Unknown values are empty or nil
+	// and encoded as null; Property returns any value by the name of the property as text.
+	Location = database.Location
+
+	// DatasetInfo is the metadata of a loaded dataset.
+	DatasetInfo = database.DatasetInfo
+
+	// Snapshot is a version of the prepared data kept in the data directory.
+	Snapshot = database.Snapshot
+
+	// Networks are the ranges of a location by IP version.
+	Networks = database.Networks
+
+	// NetworkList is a list of ranges and the minimal list of networks covering them.
+	NetworkList = database.NetworkList
+
+	// AddrRange is a range of addresses from First to Last.
+	AddrRange = database.AddrRange
+
+	// CacheStats are the counters of the cache of lookups.
+	CacheStats = database.CacheStats
+
+	// Stats are the counts of ranges and addresses of a dataset, by country and region.
+	Stats = database.Stats
+
+	// Coverage is the share of the address space of an IP version with a location.
+	Coverage = database.Coverage
+
+	// CountryStats are the counts of ranges and addresses of a country.
+	CountryStats = database.CountryStats
+
+	// RegionStats are the counts of ranges and addresses of a region.
+	RegionStats = database.RegionStats
+
+	// Uint128 is an IP number, IPv4 addresses being mapped into ::ffff:0:0/96.
+	Uint128 = database.Uint128
+)
+
+// ErrNotLoaded is returned by lookups before a dataset is loaded.
+var ErrNotLoaded = database.ErrNotLoaded
+
+// errStop stops iterating over ranges.
+var errStop = errors.New("stop")
+
+// DB is a geolocation database kept in a data directory.
+type DB struct {
+	db    *database.DB
+	cfg   config
+	sched *schedule.Scheduler // Nil without scheduled updates.
+	done  chan struct{}
+	log   log.Logger
+
+	internal []internalNetwork // Networks locating private addresses, more specific ones first.
+}
+
+// Open opens the database configured by options. It loads the data prepared in the data directory when it is fresh,
+// and downloads it otherwise. Open returns once the database is loaded, or right away with WithBackgroundLoading.
+func Open(options ...Option) (*DB, error) {
+	cfg := defaultConfig()
+	for _, o := range options {
+		o(&cfg)
+	}
+
+	db, err := newDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DB{db: db, cfg: cfg, done: make(chan struct{}), log: db.Log}
+	db.Done = d.done
+	if d.internal, err = newInternalNetworks(cfg.internal); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.reload.Schedule) != 0 {
+		s, err := schedule.Parse(cfg.reload.Schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		state := cfg.reload.StatePath
+		if len(state) == 0 {
+			state = filepath.Join(cfg.dataDir, "state.json")
+		}
+
+		if d.sched, err = schedule.New(s, cfg.reload.Jitter, state, db.Reload); err != nil {
+			return nil, err
+		}
+		d.sched.Log = d.log
+	}
+
+	if cfg.background {
+		go func() {
+			if err := d.start(); err != nil {
+				d.log.Error(err.Error())
+			}
+		}()
+
+		return d, nil
+	}
+
+	if err := d.start(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// newDB returns the database of the provider configured by cfg.
+func newDB(cfg config) (*database.DB, error) {
+	provider, err := database.NewProvider(cfg.vendor, cfg.code)
+	if err != nil {
+		return nil, err
+	}
+
+	db := database.NewDB()
+	db.Provider = provider
+	db.SHA256, db.Size = cfg.sha256, cfg.size
+	db.KeepSnapshots = cfg.keep
+	db.CacheEntries, db.CacheBytes = cfg.cacheSize, cfg.cacheBytes
+	db.Log = cfg.logger
+	if db.Log == nil {
+		db.Log = log.Default()
+	}
+
+	return db, nil
+}
+
+// start loads the database and runs scheduled updates, if any, in the background.
+func (d *DB) start() error {
+	if err := d.load(); err != nil {
+		return err
+	}
+
+	if d.sched == nil {
+		return nil
+	}
+
+	d.log.Info(fmt.Sprintf("Updates scheduled by %q, last success at %s", d.cfg.reload.Schedule,
+		d.sched.LastSuccess().Format(time.RFC3339)))
+	go d.sched.Run(d.done)
+	return nil
+}
+
+// load loads the data prepared in the data directory when it is fresh, and downloads it otherwise.
+// Prepared data is fresh when no scheduled update is due or, without a schedule, when it is younger than max age.
+func (d *DB) load() error {
+	cfg := d.cfg
+	if err := os.MkdirAll(cfg.dataDir, 0755); err != nil {
+		return err
+	}
+
+	if len(cfg.local) == 0 {
+		if m, err := database.ReadManifest(cfg.dataDir); err == nil {
+			fresh := time.Since(m.Prepared) < cfg.reload.MaxAge
+			if d.sched != nil {
+				fresh = !d.sched.Due(time.Now())
+			}
+
+			if fresh {
+				d.log.Info(fmt.Sprintf("Open prepared data of %s, version %s...", m.Source, m.Version.Format(time.RFC3339)))
+				if err = d.db.Open(cfg.token, cfg.dataDir); err == nil {
+					return nil
+				}
+				d.log.Error(fmt.Sprintf("opening prepared data: %v", err))
+			}
+		}
+	}
+
+	now := time.Now()
+	if err := d.db.Init(cfg.local, cfg.token, cfg.dataDir); err != nil {
+		return err
+	}
+
+	if d.sched != nil {
+		return d.sched.Succeeded(now)
+	}
+
+	return nil
+}
+
+// Lookup returns the geolocation of addr. IPv4 addresses and IPv4-mapped IPv6 addresses are looked up alike.
+// Special-purpose addresses, like private or loopback ones, are classified instead of being looked up,
+// and private addresses are located by the internal networks, if any. With WithUnwrap, the IPv4 address
+// embedded in a tunnelled or translated IPv6 address is located instead.
+func (d *DB) Lookup(ctx context.Context, addr netip.Addr) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	effective, embedding := addr, Embedding("")
+	if d.cfg.unwrap {
+		effective, embedding = Unwrap(addr)
+	}
+
+	var res Result
+	if class, block := Classify(effective); len(class) != 0 {
+		res = d.special(effective, class, block)
+	} else {
+		loc, err := d.db.Lookup(effective)
+		if err != nil {
+			return Result{}, err
+		}
+		res = newResult(effective, loc)
+	}
+
+	res.Addr, res.Embedding = addr, embedding
+	return res, nil
+}
+
+// Overlaps returns the records overlapping the addresses from first to last, skipping offset of them
+// and returning at most limit of them if limit is positive. More reports whether records follow the returned ones.
+// Ranges of unknown country are not returned.
+func (d *DB) Overlaps(ctx context.Context, first, last netip.Addr, offset, limit int) (records []Record, more bool,
+	err error) {
+	i := 0
+	err = d.db.Overlaps(first, last, func(loc database.Loc) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if i++; i <= offset {
+			return nil
+		}
+
+		if limit > 0 && len(records) == limit {
+			more = true
+			return errStop
+		}
+
+		records = append(records, newRecord(loc))
+		return nil
+	})
+
+	if err == errStop {
+		err = nil
+	}
+
+	return
+}
+
+// OverlapsPrefix returns the records overlapping the network p, paginated as Overlaps does.
+func (d *DB) OverlapsPrefix(ctx context.Context, p netip.Prefix, offset, limit int) (records []Record, more bool,
+	err error) {
+	if !p.IsValid() {
+		return nil, false, errors.New("invalid prefix")
+	}
+
+	first, last := database.PrefixRange(p)
+	return d.Overlaps(ctx, first, last, offset, limit)
+}
+
+// Networks returns the ranges whose property (Code, Region or City) is value, compared case-insensitively,
+// and the minimal lists of networks covering them, by IP version. Adjacent ranges are merged.
+// Regions and cities are looked up in the country code if it is not empty, and in every country otherwise.
+func (d *DB) Networks(ctx context.Context, property, value, code string) (*Networks, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return d.db.Networks(database.Properties(property), value, code)
+}
+
+// Stats returns the counts of ranges and IPv4 and IPv6 addresses by country and region,
+// the coverage of the address spaces and their gaps, computed when the dataset was loaded.
+func (d *DB) Stats(ctx context.Context) (*Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return d.db.Stats()
+}
+
+// SnapshotStats returns the statistics of the snapshot id kept in the data directory, to compare datasets over time.
+// They are those of Stats for the loaded snapshot; other snapshots are parsed on first use.
+func (d *DB) SnapshotStats(ctx context.Context, id string) (*Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return d.db.SnapshotStats(id)
+}
+
+// Reload downloads the database again and swaps it in once it is complete and valid.
+// The current dataset keeps serving lookups meanwhile and when the reload fails.
+func (d *DB) Reload() error {
+	return d.db.Reload()
+}
+
+// Rollback activates the snapshot id kept in the data directory, or the snapshot before the active one if id is empty.
+func (d *DB) Rollback(id string) error {
+	return d.db.Rollback(id)
+}
+
+// Snapshots returns the snapshots kept in the data directory, newest first.
+func (d *DB) Snapshots() ([]Snapshot, error) {
+	return database.Snapshots(d.cfg.dataDir)
+}
+
+// Info returns the lifecycle state of the database and the metadata of its dataset.
+func (d *DB) Info() Info {
+	return d.db.Info()
+}
+
+// ExportMMDB writes the loaded database, whatever its source, to w as a MaxMind DB file.
+func (d *DB) ExportMMDB(w io.Writer) error {
+	return d.db.ExportMMDB(w)
+}
+
+// Close stops scheduled updates and gives up downloads waiting to retry. Lookups are served until the database is dropped.
+func (d *DB) Close() error {
+	select {
+	case <-d.done:
+		return errors.New("already closed")
+	default:
+		close(d.done)
+	}
+
+	return nil
+}
+
+// String returns a string representation of the DB.
+func (d *DB) String() string {
+	return d.db.String()
+}
+
+// Rollback activates the snapshot id kept in the data directory of options, or the snapshot before the active one
+// if id is empty, without downloading anything. A running server picks it up on restart.
+func Rollback(id string, options ...Option) (Info, error) {
+	cfg := defaultConfig()
+	for _, o := range options {
+		o(&cfg)
+	}
+
+	db, err := newDB(cfg)
+	if err != nil {
+		return Info{}, err
+	}
+
+	db.Use(cfg.token, cfg.dataDir)
+	if err := db.Rollback(id); err != nil {
+		return db.Info(), err
+	}
+
+	return db.Info(), nil
+}
+
+// SnapshotStats parses the snapshot id kept in the data directory of options and returns its statistics,
+// without loading the active dataset or downloading anything.
+func SnapshotStats(id string, options ...Option) (*Stats, error) {
+	cfg := defaultConfig()
+	for _, o := range options {
+		o(&cfg)
+	}
+
+	db, err := newDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db.Use(cfg.token, cfg.dataDir)
+	return db.SnapshotStats(id)
+}
+
+// Snapshots returns the snapshots kept in the data directory dir, newest first.
+func Snapshots(dir string) ([]Snapshot, error) {
+	return database.Snapshots(dir)
+}
+
+// PrefixRange returns the first and the last address of the network p.
+func PrefixRange(p netip.Prefix) (first, last netip.Addr) {
+	return database.PrefixRange(p)
+}
@@ -0,0 +1,312 @@
+package iploc
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sample is the sample archive of the default provider.
+const sample = "../../test/data/DB.zip"
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := Open(WithDataDir(dir), WithLocal(sample))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	info := db.Info()
+	assert.Equal(t, Ready, info.State)
+	assert.Equal(t, "IP2Location-DB11LITEIPV6", info.Provider)
+	assert.Equal(t, "1", info.Dataset.Snapshot)
+
+	snaps, err := db.Snapshots()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(snaps))
+
+	// Prepared data is reused
+	db, err = Open(WithDataDir(dir))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", db.Info().Dataset.Snapshot)
+
+	// Errors
+	_, err = Open(WithDataDir(dir), WithProvider("vendor", ""))
+	assert.Equal(t, `unknown vendor "vendor"`, err.Error())
+
+	_, err = Open(WithDataDir(dir), WithReloadPolicy(ReloadPolicy{Schedule: "daily"}))
+	assert.Error(t, err)
+}
+
+// sampleVersion writes a copy of the sample archive with the comment to path, a new version of the same data.
+func sampleVersion(t *testing.T, path, comment string) {
+	r, err := zip.OpenReader(sample)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for _, file := range r.File {
+		assert.NoError(t, w.Copy(file))
+	}
+	assert.NoError(t, w.SetComment(comment))
+	assert.NoError(t, w.Close())
+}
+
+// messages is a logger keeping the messages.
+type messages []string
+
+func (m *messages) Info(msg string)  { *m = append(*m, msg) }
+func (m *messages) Debug(msg string) { *m = append(*m, msg) }
+func (m *messages) Error(msg string) { *m = append(*m, msg) }
+
+func TestWithLogger(t *testing.T) {
+	var first, second messages
+	db1, err := Open(WithDataDir(t.TempDir()), WithLocal(sample), WithLogger(&first))
+	assert.NoError(t, err)
+	defer db1.Close()
+
+	db2, err := Open(WithDataDir(t.TempDir()), WithLocal(sample), WithLogger(&second))
+	assert.NoError(t, err)
+	defer db2.Close()
+
+	// Every database logs to its own logger
+	assert.Contains(t, first, "Copy...")
+	assert.Contains(t, second, "Copy...")
+
+	n, m := len(first), len(second)
+	assert.NoError(t, db1.Reload())
+	assert.Greater(t, len(first), n)
+	assert.Equal(t, m, len(second))
+}
+
+func TestOpen_Background(t *testing.T) {
+	db, err := Open(WithDataDir(t.TempDir()), WithLocal(sample), WithBackgroundLoading())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.Eventually(t, func() bool { return db.Info().Ready() }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDB_Lookup(t *testing.T) {
+	db, err := Open(WithDataDir(t.TempDir()), WithLocal(sample))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	res, err := db.Lookup(context.Background(), netip.MustParseAddr("8.8.8.8"))
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("8.8.8.8"), res.Addr)
+	assert.Equal(t, netip.MustParsePrefix("8.8.8.0/24"), res.Network)
+	assert.Equal(t, netip.MustParseAddr("8.8.8.0"), res.First)
+	assert.Equal(t, netip.MustParseAddr("8.8.8.255"), res.Last)
+	assert.Equal(t, "US", res.Code)
+	assert.Equal(t, "United States of America", res.Country)
+	assert.Equal(t, "California", res.Region)
+	assert.Equal(t, "Mountain View", res.City)
+	assert.Equal(t, 37.405992, *res.Latitude)
+	assert.Equal(t, -122.078515, *res.Longitude)
+	assert.Equal(t, "94043", res.ZipCode)
+	assert.Equal(t, "-07:00", res.TimeZone)
+	assert.Equal(t, "", res.ISP)
+	assert.Nil(t, res.Elevation)
+	assert.Equal(t, "", res.Property("ISP"))
+	assert.Equal(t, "37.405992", res.Property("Latitude"))
+	assert.Contains(t, res.String(), `"City":"Mountain View"`)
+
+	b, err := json.Marshal(struct{ Result Result }{res})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Result":{"Code":"US","Country":"United States of America","Region":"California",`+
+		`"City":"Mountain View","Latitude":37.405992,"Longitude":-122.078515,"ZipCode":"94043","TimeZone":"-07:00",`+
+		`"Network":"8.8.8.0/24","First":"8.8.8.0","Last":"8.8.8.255"}}`, string(b))
+
+	// Errors
+	_, err = db.Lookup(context.Background(), netip.MustParseAddr("9.9.9.9"))
+	assert.Equal(t, "281470833330441 not found", err.Error())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.Lookup(ctx, netip.MustParseAddr("8.8.8.8"))
+	assert.Equal(t, context.Canceled, err)
+
+	assert.NoError(t, db.Close())
+	assert.Equal(t, "already closed", db.Close().Error())
+}
+
+func TestDB_Overlaps(t *testing.T) {
+	db, err := Open(WithDataDir(t.TempDir()), WithLocal(sample))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	records, more, err := db.OverlapsPrefix(ctx, netip.MustParsePrefix("8.8.4.0/22"), 0, 0)
+	assert.NoError(t, err)
+	assert.False(t, more)
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, netip.MustParseAddr("8.8.4.0"), records[0].First)
+	assert.Equal(t, netip.MustParseAddr("8.8.4.255"), records[0].Last)
+	assert.Equal(t, "Mountain View", records[0].City)
+	assert.Equal(t, "Dallas", records[3].City)
+	assert.Contains(t, records[0].String(), `"Latitude":37.405992,"Longitude":-122.078515,`)
+	assert.Contains(t, records[0].String(), `"First":"8.8.4.0","Last":"8.8.4.255"}`)
+
+	// Pages
+	records, more, err = db.OverlapsPrefix(ctx, netip.MustParsePrefix("8.8.4.0/22"), 1, 2)
+	assert.NoError(t, err)
+	assert.True(t, more)
+	assert.Equal(t, "Philadelphia", records[0].City)
+	assert.Equal(t, "Newtown Square", records[1].City)
+
+	records, more, err = db.OverlapsPrefix(ctx, netip.MustParsePrefix("8.8.4.0/22"), 2, 2)
+	assert.NoError(t, err)
+	assert.False(t, more)
+	assert.Equal(t, 2, len(records))
+
+	records, more, err = db.Overlaps(ctx, netip.MustParseAddr("8.8.8.8"), netip.MustParseAddr("8.8.8.9"), 1, 0)
+	assert.NoError(t, err)
+	assert.False(t, more)
+	assert.Empty(t, records)
+
+	// Errors
+	_, _, err = db.OverlapsPrefix(ctx, netip.Prefix{}, 0, 0)
+	assert.Equal(t, "invalid prefix", err.Error())
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = db.OverlapsPrefix(cctx, netip.MustParsePrefix("8.8.4.0/22"), 0, 0)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestDB_Networks(t *testing.T) {
+	db, err := Open(WithDataDir(t.TempDir()), WithLocal(sample))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	n, err := db.Networks(context.Background(), "City", "mountain view", "US")
+	assert.NoError(t, err)
+	assert.Equal(t, []AddrRange{
+		{First: netip.MustParseAddr("8.8.4.0"), Last: netip.MustParseAddr("8.8.4.255")},
+		{First: netip.MustParseAddr("8.8.8.0"), Last: netip.MustParseAddr("8.8.8.255")},
+	}, n.IPv4.Ranges)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("8.8.4.0/24"), netip.MustParsePrefix("8.8.8.0/24")}, n.IPv4.CIDRs)
+	assert.NotEmpty(t, n.IPv6.CIDRs)
+
+	_, err = db.Networks(context.Background(), "ZipCode", "94043", "")
+	assert.Equal(t, "property ZipCode is not indexed", err.Error())
+}
+
+func TestPrefixRange(t *testing.T) {
+	first, last := PrefixRange(netip.MustParsePrefix("203.0.113.0/22"))
+	assert.Equal(t, netip.MustParseAddr("203.0.112.0"), first)
+	assert.Equal(t, netip.MustParseAddr("203.0.115.255"), last)
+}
+
+func TestDB_ExportMMDB(t *testing.T) {
+	db, err := Open(WithDataDir(t.TempDir()), WithLocal(sample))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	assert.NoError(t, db.ExportMMDB(&buf))
+	assert.NotZero(t, buf.Len())
+}
+
+func TestRollback(t *testing.T) {
+	dir := t.TempDir()
+	local := filepath.Join(t.TempDir(), "DB.zip")
+	sampleVersion(t, local, "1")
+
+	db, err := Open(WithDataDir(dir), WithLocal(local))
+	assert.NoError(t, err)
+	sampleVersion(t, local, "2")
+	assert.NoError(t, db.Reload())
+	assert.Equal(t, "2", db.Info().Dataset.Snapshot)
+	assert.NoError(t, db.Close())
+
+	info, err := Rollback("", WithDataDir(dir))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", info.Dataset.Snapshot)
+
+	_, err = Rollback("5", WithDataDir(dir))
+	assert.Equal(t, `snapshot "5" not found`, err.Error())
+
+	snaps, err := Snapshots(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(snaps))
+	assert.True(t, snaps[1].Active)
+}
+
+func TestDB_Stats(t *testing.T) {
+	db, err := Open(WithDataDir(t.TempDir()), WithLocal(sample))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	st, err := db.Stats(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "1", st.Snapshot)
+	assert.Equal(t, 18, st.Ranges)
+	assert.Equal(t, "5632", st.IPv4.Addresses.String())
+	assert.Equal(t, "United States of America", st.Countries["US"].Country)
+	assert.Equal(t, 1, st.Countries["CA"].Regions["Quebec"].Ranges)
+}
+
+func TestSnapshotStats(t *testing.T) {
+	dir := t.TempDir()
+	local := filepath.Join(t.TempDir(), "DB.zip")
+	sampleVersion(t, local, "1")
+
+	db, err := Open(WithDataDir(dir), WithLocal(local))
+	assert.NoError(t, err)
+	defer db.Close()
+	sampleVersion(t, local, "2")
+	assert.NoError(t, db.Reload())
+
+	st, err := db.SnapshotStats(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", st.Snapshot)
+	assert.Equal(t, 18, st.Ranges)
+
+	st, err = SnapshotStats("2", WithDataDir(dir))
+	assert.NoError(t, err)
+	assert.Equal(t, "2", st.Snapshot)
+	assert.Equal(t, 18, st.Ranges)
+
+	_, err = SnapshotStats("5", WithDataDir(dir))
+	assert.Equal(t, `snapshot "5" not found`, err.Error())
+}
+
+func TestWithCache(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "DB.zip")
+	sampleVersion(t, local, "1")
+
+	db, err := Open(WithDataDir(t.TempDir()), WithLocal(local), WithCache(100, 1<<20))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for _, s := range []string{"8.8.8.8", "8.8.8.9", "8.8.8.8"} {
+		res, err := db.Lookup(context.Background(), netip.MustParseAddr(s))
+		assert.NoError(t, err)
+		assert.Equal(t, netip.MustParseAddr(s), res.Addr)
+		assert.Equal(t, "Mountain View", res.City)
+	}
+
+	st := db.Info().Cache
+	assert.Equal(t, 1, st.Entries)
+	assert.Equal(t, uint64(2), st.Hits)
+	assert.Equal(t, uint64(1), st.Misses)
+
+	// A new version invalidates the cache
+	sampleVersion(t, local, "2")
+	assert.NoError(t, db.Reload())
+	assert.Equal(t, 0, db.Info().Cache.Entries)
+}
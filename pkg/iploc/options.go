@@ -0,0 +1,146 @@
+package iploc
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/ivanglie/iploc/pkg/log"
+)
+
+// Option configures the database opened by Open.
+type Option func(*config)
+
+// ReloadPolicy defines when the database is downloaded again.
+type ReloadPolicy struct {
+	// Schedule of updates: an interval like 720h or a cron expression like "0 3 * * 3". Empty disables updates.
+	Schedule string
+
+	// Jitter is the maximum random delay of scheduled updates.
+	Jitter time.Duration
+
+	// MaxAge is the age of the prepared data after which it is downloaded again on Open, unless updates are scheduled.
+	MaxAge time.Duration
+
+	// StatePath is the file of the time of the last successful update, state.json in the data directory if empty.
+	StatePath string
+}
+
+type config struct {
+	dataDir    string
+	vendor     string
+	code       string
+	token      string
+	local      string
+	sha256     string
+	size       int64
+	keep       int
+	cacheSize  int
+	cacheBytes int64
+	internal   map[netip.Prefix]map[string]string
+	unwrap     bool
+	reload     ReloadPolicy
+	logger     log.Logger
+	background bool
+}
+
+func defaultConfig() config {
+	return config{dataDir: "data", vendor: IP2Location, reload: ReloadPolicy{MaxAge: 720 * time.Hour}}
+}
+
+// WithDataDir sets the directory of the prepared data, data by default.
+func WithDataDir(dir string) Option {
+	return func(c *config) {
+		c.dataDir = dir
+	}
+}
+
+// WithProvider sets the database vendor and its database code: an IP2Location code of DB1 to DB26
+// (DB11LITEIPV6 by default, BIN codes like DB11LITEBINIPV6 are read in BIN format) or a MaxMind edition ID
+// (GeoLite2-City by default). IP2Location DB11LITEIPV6 is used without the option.
+func WithProvider(vendor, code string) Option {
+	return func(c *config) {
+		c.vendor, c.code = vendor, code
+	}
+}
+
+// WithToken sets the IP2Location download token or the MaxMind license key.
+func WithToken(token string) Option {
+	return func(c *config) {
+		c.token = token
+	}
+}
+
+// WithLocal copies the archive at path, in the format of the provider, instead of downloading the database,
+// like a sample for local development or an archive downloaded beforehand. Prepared data is not reused.
+func WithLocal(path string) Option {
+	return func(c *config) {
+		c.local = path
+	}
+}
+
+// WithVerification sets the expected SHA-256 (in hex) and size of downloaded archives. Zero values are not verified.
+func WithVerification(sha256 string, size int64) Option {
+	return func(c *config) {
+		c.sha256, c.size = sha256, size
+	}
+}
+
+// WithSnapshots sets the number of dataset snapshots kept in the data directory, 3 by default.
+func WithSnapshots(keep int) Option {
+	return func(c *config) {
+		c.keep = keep
+	}
+}
+
+// WithCache enables an LRU cache of lookups bounded by entries and by the estimated bytes of the entries.
+// A zero bound is no bound, and the cache is disabled when both are zero, as it is by default.
+// Locations are cached by the /24 (IPv4) or /64 (IPv6) block of the address when their range covers it,
+// concurrent lookups of the same address are coalesced and every reload starts with an empty cache.
+func WithCache(entries int, bytes int64) Option {
+	return func(c *config) {
+		c.cacheSize, c.cacheBytes = entries, bytes
+	}
+}
+
+// WithInternalNetworks locates private addresses, which are not in the database, by the networks of m
+// with their properties, like Code, City or Latitude. Networks must be within the private-use ranges
+// of IPv4 or the unique local range of IPv6; the most specific network containing an address locates it.
+func WithInternalNetworks(m map[netip.Prefix]map[string]string) Option {
+	return func(c *config) {
+		c.internal = m
+	}
+}
+
+// WithUnwrap makes lookups of 6to4, Teredo, NAT64 and IPv4-compatible IPv6 addresses locate
+// the IPv4 address they embed. Results report both the address and the effective one.
+func WithUnwrap() Option {
+	return func(c *config) {
+		c.unwrap = true
+	}
+}
+
+// WithReloadPolicy sets when the database is downloaded again. By default, prepared data older than 720h
+// is downloaded again on Open and no updates are scheduled.
+func WithReloadPolicy(p ReloadPolicy) Option {
+	return func(c *config) {
+		if p.MaxAge == 0 {
+			p.MaxAge = c.reload.MaxAge
+		}
+		c.reload = p
+	}
+}
+
+// WithLogger sets the logger of the database, the logger of pkg/log by default.
+func WithLogger(l log.Logger) Option {
+	return func(c *config) {
+		c.logger = l
+	}
+}
+
+// WithBackgroundLoading makes Open return right away and load the database in the background.
+// Lookups fail with ErrNotLoaded and Info reports the state until it is loaded.
+func WithBackgroundLoading() Option {
+	return func(c *config) {
+		c.background = true
+	}
+}
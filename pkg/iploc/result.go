@@ -0,0 +1,96 @@
+package iploc
+
+import (
+	"encoding/json"
+	"net/netip"
+
+	"github.com/ivanglie/iploc/internal/database"
+)
+
+// Result is the geolocation of an address: its typed location, with the largest network of the netblock
+// containing Addr and the range of the netblock.
+// Special-purpose addresses have a class and are not looked up: their netblock is their special-purpose block
+// or the internal network containing them, which locates them.
+type Result struct {
+	Addr  netip.Addr // Address looked up.
+	Class Class      // Class of a special-purpose address, empty for global addresses.
+
+	// Effective is the address located: the IPv4 address embedded in Addr if it was unwrapped, Addr otherwise.
+	Effective netip.Addr
+	Embedding Embedding // Form of Addr if it was unwrapped.
+
+	Location
+}
+
+// Record is a range of the database, from First to Last, with its location.
+type Record struct {
+	Location
+}
+
+// newResult returns the result of the lookup of addr.
+func newResult(addr netip.Addr, loc database.Loc) Result {
+	return Result{Addr: addr, Effective: addr, Location: loc.Location()}
+}
+
+// newRecord returns the record of the range of loc.
+func newRecord(loc database.Loc) Record {
+	return Record{Location: loc.Location()}
+}
+
+// IsProperty reports whether name is a property of some database type, like City or ISP.
+func IsProperty(name string) bool {
+	return database.IsProperty(database.Properties(name))
+}
+
+// MarshalJSON encodes the location of the result with its network and range, preceded by the address,
+// the effective address and the embedding of an unwrapped address and the class of a special-purpose one.
+func (r Result) MarshalJSON() ([]byte, error) {
+	head := struct {
+		Address   *netip.Addr `json:",omitempty"`
+		Effective *netip.Addr `json:",omitempty"`
+		Embedding Embedding   `json:",omitempty"`
+		Class     Class       `json:",omitempty"`
+	}{Embedding: r.Embedding, Class: r.Class}
+	if len(r.Embedding) != 0 {
+		head.Address, head.Effective = &r.Addr, &r.Effective
+	}
+
+	return joinObjects(head, r.Location)
+}
+
+// String returns the result as JSON, as the HTTP API does.
+func (r Result) String() string {
+	return jsonString(r)
+}
+
+// joinObjects returns the JSON object of the fields of the objects a and b.
+func joinObjects(a, b interface{}) ([]byte, error) {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(ja) == 2:
+		return jb, nil
+	case len(jb) == 2:
+		return ja, nil
+	}
+
+	return append(append(ja[:len(ja)-1], ','), jb[1:]...), nil
+}
+
+// jsonString returns the JSON of v, or an empty object if it cannot be encoded.
+func jsonString(v json.Marshaler) string {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return "{}"
+	}
+
+	return string(b)
+}
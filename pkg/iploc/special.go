@@ -0,0 +1,165 @@
+package iploc
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/ivanglie/iploc/internal/database"
+)
+
+// Class is the class of a special-purpose address by the IANA IPv4 and IPv6 Special-Purpose Address Registries.
+// Global addresses have no class.
+type Class string
+
+const (
+	Private       Class = "private"       // Private-use (RFC 1918) and unique local (RFC 4193) addresses.
+	Loopback      Class = "loopback"      // Loopback addresses (RFC 1122, RFC 4291).
+	LinkLocal     Class = "link-local"    // Link-local addresses (RFC 3927, RFC 4291).
+	CGNAT         Class = "cgnat"         // Shared address space of carrier-grade NAT (RFC 6598).
+	Multicast     Class = "multicast"     // Multicast addresses (RFC 5771, RFC 4291).
+	Documentation Class = "documentation" // Documentation addresses (RFC 5737, RFC 3849, RFC 9637).
+	Reserved      Class = "reserved"      // Unspecified, reserved for future use, benchmarking and protocol assignments.
+	Bogon         Class = "bogon"         // Deprecated addresses and IPv6 addresses out of the global unicast space.
+)
+
+// specialBlock is a special-purpose address block.
+type specialBlock struct {
+	prefix netip.Prefix
+	class  Class
+}
+
+// specialBlocks are the special-purpose address blocks, more specific blocks first.
+var specialBlocks = func() []specialBlock {
+	blocks := []struct {
+		prefix string
+		class  Class
+	}{
+		{"0.0.0.0/8", Reserved},
+		{"10.0.0.0/8", Private},
+		{"100.64.0.0/10", CGNAT},
+		{"127.0.0.0/8", Loopback},
+		{"169.254.0.0/16", LinkLocal},
+		{"172.16.0.0/12", Private},
+		{"192.0.0.0/24", Reserved},
+		{"192.0.2.0/24", Documentation},
+		{"192.88.99.0/24", Bogon},
+		{"192.168.0.0/16", Private},
+		{"198.18.0.0/15", Reserved},
+		{"198.51.100.0/24", Documentation},
+		{"203.0.113.0/24", Documentation},
+		{"224.0.0.0/4", Multicast},
+		{"240.0.0.0/4", Reserved},
+
+		{"::/128", Reserved},
+		{"::1/128", Loopback},
+		{"64:ff9b::/96", ""}, // IPv4-IPv6 translation, global.
+		{"64:ff9b:1::/48", Reserved},
+		{"100::/64", Reserved},
+		{"2001::/32", ""},     // Teredo, global and unwrapped to its IPv4 address.
+		{"2001:1::1/128", ""}, // Port Control Protocol anycast, global.
+		{"2001:1::2/128", ""}, // TURN anycast, global.
+		{"2001:1::3/128", ""}, // DNS-SD SRP anycast, global.
+		{"2001:2::/48", Reserved},
+		{"2001:3::/32", ""},     // AMT, global.
+		{"2001:4:112::/48", ""}, // AS112, global.
+		{"2001:20::/28", Reserved},
+		{"2001:30::/28", ""}, // DRIP, global.
+		{"2001::/23", Reserved},
+		{"2001:db8::/32", Documentation},
+		{"3fff::/20", Documentation},
+		{"5f00::/16", Reserved},
+		{"2000::/3", ""},
+		{"fc00::/7", Private},
+		{"fe80::/10", LinkLocal},
+		{"ff00::/8", Multicast},
+		{"::/0", Bogon},
+	}
+
+	s := make([]specialBlock, len(blocks))
+	for i, b := range blocks {
+		s[i] = specialBlock{netip.MustParsePrefix(b.prefix), b.class}
+	}
+
+	return s
+}()
+
+// Classify returns the class of addr, if it is a special-purpose address, with its special-purpose block.
+// IPv4-mapped IPv6 addresses are classified as IPv4 addresses, the zone is ignored.
+func Classify(addr netip.Addr) (Class, netip.Prefix) {
+	if !addr.IsValid() {
+		return "", netip.Prefix{}
+	}
+
+	addr = addr.Unmap().WithZone("")
+	for _, b := range specialBlocks {
+		if b.prefix.Contains(addr) {
+			if len(b.class) == 0 {
+				break
+			}
+			return b.class, b.prefix
+		}
+	}
+
+	return "", netip.Prefix{}
+}
+
+// internalNetwork is a private network with its location.
+type internalNetwork struct {
+	prefix netip.Prefix
+	loc    database.Loc
+}
+
+// newInternalNetworks validates the private networks of m with their properties, like Code or City,
+// and returns them, more specific networks first.
+func newInternalNetworks(m map[netip.Prefix]map[string]string) ([]internalNetwork, error) {
+	networks := make([]internalNetwork, 0, len(m))
+	for p, props := range m {
+		if !p.IsValid() {
+			return nil, fmt.Errorf("invalid prefix %v", p)
+		}
+
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+
+		p = p.Masked()
+		if c, block := Classify(p.Addr()); c != Private || block.Bits() > p.Bits() {
+			return nil, fmt.Errorf("network %v is not private", p)
+		}
+
+		loc := database.Loc{Properties: make(map[database.Properties]string, len(props))}
+		for k, v := range props {
+			if !IsProperty(k) {
+				return nil, fmt.Errorf("unknown property %q of network %v", k, p)
+			}
+			loc.Properties[database.Properties(k)] = v
+		}
+
+		networks = append(networks, internalNetwork{prefix: p, loc: loc})
+	}
+
+	sort.Slice(networks, func(i, j int) bool { return networks[i].prefix.Bits() > networks[j].prefix.Bits() })
+	return networks, nil
+}
+
+// special returns the result of the special-purpose address addr of class in block,
+// located by the internal networks if it is private and one of them contains it.
+func (d *DB) special(addr netip.Addr, class Class, block netip.Prefix) Result {
+	network := block
+	var loc database.Loc
+	if class == Private {
+		a := addr.Unmap().WithZone("")
+		for _, n := range d.internal {
+			if n.prefix.Contains(a) {
+				network, loc = n.prefix, n.loc
+				break
+			}
+		}
+	}
+
+	r := Result{Addr: addr, Class: class, Effective: addr, Location: loc.Location()}
+	r.Network = network
+	r.First, r.Last = PrefixRange(network)
+	return r
+}
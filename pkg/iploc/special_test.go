@@ -0,0 +1,139 @@
+package iploc
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		addr  string
+		class Class
+		block string
+	}{
+		{"10.0.0.1", Private, "10.0.0.0/8"},
+		{"172.31.255.255", Private, "172.16.0.0/12"},
+		{"192.168.1.1", Private, "192.168.0.0/16"},
+		{"::ffff:192.168.1.1", Private, "192.168.0.0/16"},
+		{"fd12:3456::1", Private, "fc00::/7"},
+		{"127.0.0.1", Loopback, "127.0.0.0/8"},
+		{"::1", Loopback, "::1/128"},
+		{"169.254.169.254", LinkLocal, "169.254.0.0/16"},
+		{"fe80::1%eth0", LinkLocal, "fe80::/10"},
+		{"100.64.0.1", CGNAT, "100.64.0.0/10"},
+		{"224.0.0.251", Multicast, "224.0.0.0/4"},
+		{"ff02::fb", Multicast, "ff00::/8"},
+		{"192.0.2.1", Documentation, "192.0.2.0/24"},
+		{"198.51.100.1", Documentation, "198.51.100.0/24"},
+		{"203.0.113.1", Documentation, "203.0.113.0/24"},
+		{"2001:db8::1", Documentation, "2001:db8::/32"},
+		{"3fff::1", Documentation, "3fff::/20"},
+		{"0.0.0.0", Reserved, "0.0.0.0/8"},
+		{"240.0.0.1", Reserved, "240.0.0.0/4"},
+		{"255.255.255.255", Reserved, "240.0.0.0/4"},
+		{"198.18.0.1", Reserved, "198.18.0.0/15"},
+		{"::", Reserved, "::/128"},
+		{"64:ff9b:1::1", Reserved, "64:ff9b:1::/48"},
+		{"2001:1::4", Reserved, "2001::/23"},
+		{"2001:1ff::1", Reserved, "2001::/23"},
+		{"2001:2::1", Reserved, "2001:2::/48"},
+		{"2001:20::1", Reserved, "2001:20::/28"},
+		{"5f00::1", Reserved, "5f00::/16"},
+		{"192.88.99.1", Bogon, "192.88.99.0/24"},
+		{"4000::1", Bogon, "::/0"},
+		{"::8.8.8.8", Bogon, "::/0"},
+
+		// Global
+		{"8.8.8.8", "", ""},
+		{"100.128.0.1", "", ""},
+		{"2001:4860:4860::8888", "", ""},
+		{"64:ff9b::808:808", "", ""},
+		{"2001:0:4136:e378:8000:63bf:f7f7:f7f7", "", ""},
+		{"2001:1::1", "", ""},
+		{"2001:4:112::1", "", ""},
+		{"2001:200::1", "", ""},
+	}
+
+	for _, tt := range tests {
+		class, block := Classify(netip.MustParseAddr(tt.addr))
+		assert.Equal(t, tt.class, class, tt.addr)
+		if len(tt.block) != 0 {
+			assert.Equal(t, netip.MustParsePrefix(tt.block), block, tt.addr)
+		} else {
+			assert.False(t, block.IsValid(), tt.addr)
+		}
+	}
+
+	class, _ := Classify(netip.Addr{})
+	assert.Equal(t, Class(""), class)
+}
+
+func TestDB_Lookup_Special(t *testing.T) {
+	db, err := Open(WithDataDir(t.TempDir()), WithLocal(sample), WithInternalNetworks(map[netip.Prefix]map[string]string{
+		netip.MustParsePrefix("10.0.0.0/8"):          {"Code": "DE", "Country": "Germany"},
+		netip.MustParsePrefix("10.1.0.0/16"):         {"Code": "DE", "Country": "Germany", "City": "Berlin"},
+		netip.MustParsePrefix("::ffff:10.2.0.0/112"): {"Code": "FR", "City": "Paris"},
+	}))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	// Special-purpose addresses are not looked up
+	res, err := db.Lookup(ctx, netip.MustParseAddr("127.0.0.1"))
+	assert.NoError(t, err)
+	assert.Equal(t, Loopback, res.Class)
+	assert.Equal(t, netip.MustParsePrefix("127.0.0.0/8"), res.Network)
+	assert.Equal(t, netip.MustParseAddr("127.0.0.0"), res.First)
+	assert.Equal(t, netip.MustParseAddr("127.255.255.255"), res.Last)
+	assert.Equal(t, "", res.Code)
+	assert.Nil(t, res.Latitude)
+	assert.Equal(t, `{"Class":"loopback","Code":null,"Country":null,"Region":null,"City":null,"Latitude":null,`+
+		`"Longitude":null,"ZipCode":null,"TimeZone":null,"Network":"127.0.0.0/8","First":"127.0.0.0",`+
+		`"Last":"127.255.255.255"}`, res.String())
+
+	// Private addresses are located by the most specific internal network
+	res, err = db.Lookup(ctx, netip.MustParseAddr("10.1.2.3"))
+	assert.NoError(t, err)
+	assert.Equal(t, Private, res.Class)
+	assert.Equal(t, netip.MustParsePrefix("10.1.0.0/16"), res.Network)
+	assert.Equal(t, "Berlin", res.City)
+	assert.Equal(t, `{"Class":"private","Code":"DE","Country":"Germany","City":"Berlin","Network":"10.1.0.0/16",`+
+		`"First":"10.1.0.0","Last":"10.1.255.255"}`, res.String())
+
+	res, err = db.Lookup(ctx, netip.MustParseAddr("10.200.0.1"))
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("10.0.0.0/8"), res.Network)
+	assert.Equal(t, "", res.City)
+
+	res, err = db.Lookup(ctx, netip.MustParseAddr("::ffff:10.2.0.1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Paris", res.City)
+
+	res, err = db.Lookup(ctx, netip.MustParseAddr("192.168.0.1"))
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("192.168.0.0/16"), res.Network)
+	assert.Equal(t, "", res.Code)
+
+	// Global addresses are looked up
+	res, err = db.Lookup(ctx, netip.MustParseAddr("8.8.8.8"))
+	assert.NoError(t, err)
+	assert.Equal(t, Class(""), res.Class)
+	assert.NotContains(t, res.String(), "Class")
+
+	// Errors
+	for p, err := range map[string]string{
+		"8.8.8.0/24":  "network 8.8.8.0/24 is not private",
+		"10.0.0.0/7":  "network 10.0.0.0/7 is not private",
+		"fd00::/8":    `unknown property "Town" of network fd00::/8`,
+		"127.0.0.0/8": "network 127.0.0.0/8 is not private",
+	} {
+		_, e := Open(WithDataDir(t.TempDir()), WithLocal(sample), WithInternalNetworks(map[netip.Prefix]map[string]string{
+			netip.MustParsePrefix(p): {"Town": "x"},
+		}))
+		assert.Equal(t, err, e.Error())
+	}
+}
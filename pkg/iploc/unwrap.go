@@ -0,0 +1,43 @@
+package iploc
+
+import "net/netip"
+
+// Embedding is a form of IPv6 addresses carrying an IPv4 address.
+type Embedding string
+
+const (
+	SixToFour      Embedding = "6to4"            // 2002::/16, the IPv4 address follows the prefix (RFC 3056).
+	Teredo         Embedding = "teredo"          // 2001::/32, the obfuscated IPv4 address of the client ends it (RFC 4380).
+	NAT64          Embedding = "nat64"           // 64:ff9b::/96, the IPv4 address ends it (RFC 6052).
+	IPv4Compatible Embedding = "ipv4-compatible" // ::/96 but :: and ::1, deprecated (RFC 4291).
+)
+
+var (
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+	teredoPrefix    = netip.MustParsePrefix("2001::/32")
+	nat64Prefix     = netip.MustParsePrefix("64:ff9b::/96")
+	compatPrefix    = netip.MustParsePrefix("::/96")
+)
+
+// Unwrap returns the IPv4 address embedded in addr with the form of addr, if addr is a 6to4, Teredo, NAT64
+// or IPv4-compatible IPv6 address, and addr with no form otherwise.
+func Unwrap(addr netip.Addr) (netip.Addr, Embedding) {
+	if !addr.Is6() || addr.Is4In6() {
+		return addr, ""
+	}
+
+	a := addr.WithZone("")
+	b := a.As16()
+	switch {
+	case sixToFourPrefix.Contains(a):
+		return netip.AddrFrom4([4]byte{b[2], b[3], b[4], b[5]}), SixToFour
+	case teredoPrefix.Contains(a):
+		return netip.AddrFrom4([4]byte{^b[12], ^b[13], ^b[14], ^b[15]}), Teredo
+	case nat64Prefix.Contains(a):
+		return netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]}), NAT64
+	case compatPrefix.Contains(a) && !a.IsUnspecified() && !a.IsLoopback():
+		return netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]}), IPv4Compatible
+	}
+
+	return addr, ""
+}
@@ -0,0 +1,78 @@
+package iploc
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnwrap(t *testing.T) {
+	tests := []struct {
+		addr      string
+		effective string
+		embedding Embedding
+	}{
+		{"2002:808:808::1", "8.8.8.8", SixToFour},
+		{"2001:0:4136:e378:8000:63bf:f7f7:f7f7", "8.8.8.8", Teredo},
+		{"64:ff9b::808:808", "8.8.8.8", NAT64},
+		{"64:ff9b::8.8.4.4%eth0", "8.8.4.4", NAT64},
+		{"::8.8.8.8", "8.8.8.8", IPv4Compatible},
+
+		// Not embedding IPv4 addresses
+		{"::", "::", ""},
+		{"::1", "::1", ""},
+		{"::ffff:8.8.8.8", "::ffff:8.8.8.8", ""},
+		{"8.8.8.8", "8.8.8.8", ""},
+		{"2001:4860:4860::8888", "2001:4860:4860::8888", ""},
+		{"2001:db8::1", "2001:db8::1", ""},
+	}
+
+	for _, tt := range tests {
+		effective, embedding := Unwrap(netip.MustParseAddr(tt.addr))
+		assert.Equal(t, netip.MustParseAddr(tt.effective), effective.WithZone(""), tt.addr)
+		assert.Equal(t, tt.embedding, embedding, tt.addr)
+	}
+}
+
+func TestDB_Lookup_Unwrap(t *testing.T) {
+	db, err := Open(WithDataDir(t.TempDir()), WithLocal(sample), WithUnwrap())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	res, err := db.Lookup(ctx, netip.MustParseAddr("2002:808:808::1"))
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("2002:808:808::1"), res.Addr)
+	assert.Equal(t, netip.MustParseAddr("8.8.8.8"), res.Effective)
+	assert.Equal(t, SixToFour, res.Embedding)
+	assert.Equal(t, netip.MustParsePrefix("8.8.8.0/24"), res.Network)
+	assert.Equal(t, "Mountain View", res.City)
+	assert.Equal(t, `{"Address":"2002:808:808::1","Effective":"8.8.8.8","Embedding":"6to4","Code":"US",`+
+		`"Country":"United States of America","Region":"California","City":"Mountain View","Latitude":37.405992,`+
+		`"Longitude":-122.078515,"ZipCode":"94043","TimeZone":"-07:00","Network":"8.8.8.0/24","First":"8.8.8.0",`+
+		`"Last":"8.8.8.255"}`, res.String())
+
+	// Embedded special-purpose addresses are classified
+	res, err = db.Lookup(ctx, netip.MustParseAddr("64:ff9b::10.0.0.1"))
+	assert.NoError(t, err)
+	assert.Equal(t, Private, res.Class)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), res.Effective)
+	assert.Contains(t, res.String(), `"Embedding":"nat64","Class":"private"`)
+
+	// Other addresses are located as they are
+	res, err = db.Lookup(ctx, netip.MustParseAddr("8.8.8.8"))
+	assert.NoError(t, err)
+	assert.Equal(t, res.Addr, res.Effective)
+	assert.Equal(t, Embedding(""), res.Embedding)
+	assert.NotContains(t, res.String(), "Effective")
+
+	// Without the option
+	db2, err := Open(WithDataDir(t.TempDir()), WithLocal(sample))
+	assert.NoError(t, err)
+	defer db2.Close()
+
+	_, err = db2.Lookup(ctx, netip.MustParseAddr("2002:808:808::1"))
+	assert.Error(t, err)
+}
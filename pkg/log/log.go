@@ -76,3 +76,29 @@ func Error(msg string) {
 		logger.Error(msg)
 	}
 }
+
+// SetLogger replaces the logger, the one configured by SetLogConfig by default.
+// l is the logger, nil disables logging.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// Default returns the logger of the package functions, which logs by the logger set by SetLogger at each call.
+func Default() Logger {
+	return pkgLogger{}
+}
+
+// pkgLogger logs by the package functions.
+type pkgLogger struct{}
+
+func (pkgLogger) Info(msg string) {
+	Info(msg)
+}
+
+func (pkgLogger) Debug(msg string) {
+	Debug(msg)
+}
+
+func (pkgLogger) Error(msg string) {
+	Error(msg)
+}
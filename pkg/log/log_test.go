@@ -42,3 +42,27 @@ func TestInitLogLevel(t *testing.T) {
 	SetLogConfig(zerolog.ErrorLevel, &buf)
 	assert.Equal(t, zerolog.ErrorLevel, zlogger.GetLevel(), "Initial log level should be Error")
 }
+
+type testLogger struct {
+	msgs []string
+}
+
+func (l *testLogger) Info(msg string)  { l.msgs = append(l.msgs, "info: "+msg) }
+func (l *testLogger) Debug(msg string) { l.msgs = append(l.msgs, "debug: "+msg) }
+func (l *testLogger) Error(msg string) { l.msgs = append(l.msgs, "error: "+msg) }
+
+func TestSetLogger(t *testing.T) {
+	defer SetLogConfig(zerolog.InfoLevel, nil)
+
+	l := &testLogger{}
+	SetLogger(l)
+	Info("Info message")
+	Debug("Debug message")
+	Error("Error message")
+	assert.Equal(t, []string{"info: Info message", "debug: Debug message", "error: Error message"}, l.msgs)
+
+	// Logging is disabled without a logger
+	SetLogger(nil)
+	Info("Info message")
+	assert.Equal(t, 3, len(l.msgs))
+}